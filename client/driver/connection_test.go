@@ -0,0 +1,205 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	p "github.com/WatchJani/memCashed/client/parser"
+)
+
+// request is a request frame as the fake server reads it.
+type request struct {
+	operation byte
+	id        uint32
+	key       []byte
+	body      []byte
+}
+
+// fakeServer connects a driver of one connection per server to the server side of a pipe for each handler.
+func fakeServer(t *testing.T, handlers ...func(net.Conn)) *Driver {
+	t.Helper()
+
+	driver := &Driver{Conn: make([]Connection, len(handlers))}
+	for i, handler := range handlers {
+		client, server := net.Pipe()
+		t.Cleanup(func() {
+			client.Close()
+			server.Close()
+		})
+
+		driver.Conn[i] = Connection{NumberOfConnection: 1, PayloadCh: make(chan Communicator)}
+		go newSingleConnection(driver.Conn[i].PayloadCh, client).Worker()
+		go handler(server)
+	}
+
+	return driver
+}
+
+// readRequest reads one request frame from the connection.
+func readRequest(conn net.Conn) (request, error) {
+	size := make([]byte, 4)
+	if _, err := io.ReadFull(conn, size); err != nil {
+		return request{}, err
+	}
+
+	payload := make([]byte, p.DecodeLength(size))
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		return request{}, err
+	}
+
+	operation, keySize, _, bodySize := p.Decode(payload)
+	key := payload[18 : 18+keySize]
+
+	return request{operation, p.RequestID(payload), key, payload[18+keySize : 18+keySize+bodySize]}, nil
+}
+
+// reply writes a response frame for the request to the connection.
+func reply(conn net.Conn, req request, status byte, body []byte) error {
+	_, err := conn.Write(p.EncodeResponse(status, 0, req.id, 0, body))
+	return err
+}
+
+func TestOutOfOrderReplies(t *testing.T) {
+	driver := fakeServer(t, func(conn net.Conn) {
+		requests := make([]request, 3)
+		for i := range requests {
+			var err error
+			if requests[i], err = readRequest(conn); err != nil {
+				return
+			}
+		}
+
+		// the replies come back in the reverse order of the requests
+		for i := len(requests) - 1; i >= 0; i-- {
+			if err := reply(conn, requests[i], p.StatusOK, requests[i].key); err != nil {
+				return
+			}
+		}
+	})
+
+	responses := make([]<-chan Response, 3)
+	for i := range responses {
+		var err error
+		if responses[i], err = driver.GetReq(fmt.Appendf(nil, "key-%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, response := range responses {
+		res := <-response
+		if expected := fmt.Sprint("key-", i); res.Err() != nil || string(res.Value) != expected {
+			t.Errorf("request %d: expected %s | get %s %v", i, expected, res.Value, res.Err())
+		}
+	}
+}
+
+func TestConnectionFailure(t *testing.T) {
+	driver := fakeServer(t, func(conn net.Conn) {
+		// the server reads both requests and goes away without replying
+		for range 2 {
+			if _, err := readRequest(conn); err != nil {
+				return
+			}
+		}
+
+		conn.Close()
+	})
+
+	responses := make([]<-chan Response, 2)
+	for i := range responses {
+		var err error
+		if responses[i], err = driver.SetReq(fmt.Appendf(nil, "key-%d", i), []byte("value"), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, response := range responses {
+		if err := (<-response).Err(); !errors.Is(err, io.EOF) {
+			t.Errorf("request %d: expected %v | get %v", i, io.EOF, err)
+		}
+	}
+
+	// a request sent after the failure fails too
+	response, err := driver.GetReq([]byte("key-0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := (<-response).Err(); err == nil {
+		t.Error("request after the failure: expected an error")
+	}
+}
+
+// multiGetServer answers multi get requests, keys starting with "miss" are not found
+// and the value of every other key is the key with the name of the server in front.
+func multiGetServer(name string) func(net.Conn) {
+	return func(conn net.Conn) {
+		for {
+			req, err := readRequest(conn)
+			if err != nil || req.operation != 'g' {
+				return
+			}
+
+			var entries []byte
+			for body := req.body; len(body) > 0; body = body[1+int(body[0]):] {
+				key := string(body[1 : 1+int(body[0])])
+
+				status, value := p.StatusOK, []byte(name+"/"+key)
+				if len(key) >= 4 && key[:4] == "miss" {
+					status, value = p.StatusNotFound, nil
+				}
+
+				entry := make([]byte, 17)
+				entry[0] = status
+				p.LittleEndianEncode(entry[13:17], uint32(len(value)))
+				entries = append(append(entries, entry...), value...)
+			}
+
+			if err := reply(conn, req, p.StatusOK, entries); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func TestGetMulti(t *testing.T) {
+	driver := fakeServer(t, multiGetServer("a"), multiGetServer("b"))
+
+	var keys [][]byte
+	routes := make(map[int]bool)
+	for i := range 10 {
+		key := fmt.Appendf(nil, "key-%d", i)
+		keys = append(keys, key)
+		routes[driver.Route(key)] = true
+	}
+
+	keys = append(keys, []byte("missing"))
+
+	if len(routes) != 2 {
+		t.Fatalf("keys: expected to route to both servers | get %v", routes)
+	}
+
+	results, err := driver.GetMulti(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != len(keys) {
+		t.Fatalf("results: expected %d | get %d", len(keys), len(results))
+	}
+
+	// every key is answered by the server it routes to
+	for _, key := range keys[:10] {
+		expected := fmt.Sprintf("%c/%s", "ab"[driver.Route(key)], key)
+		if res := results[string(key)]; !res.IsHit() || string(res.Value) != expected {
+			t.Errorf("%s: expected %s | get %s %v", key, expected, res.Value, res.Err())
+		}
+	}
+
+	if err := results["missing"].Err(); err != ErrNotFound {
+		t.Errorf("missing: expected %v | get %v", ErrNotFound, err)
+	}
+}
@@ -1,10 +1,11 @@
 package client
 
 import (
-	"hash"
 	"hash/fnv"
+	"io"
 	"log"
 	"net"
+	"sync"
 
 	"github.com/WatchJani/memCashed/client/internal/types"
 	p "github.com/WatchJani/memCashed/client/parser"
@@ -18,19 +19,19 @@ type Connection struct {
 	PayloadCh chan Communicator // Channel used for sending payloads for communication.
 }
 
+// Driver spreads the keys over the configured servers by their hash.
 type Driver struct {
-	hash.Hash32
 	Conn []Connection
 }
 
 // Communicator struct represents a payload and response channel for communication.
 type Communicator struct {
-	payload  []byte      // Payload data to be sent.
-	response chan []byte // Channel to receive the response from the server.
+	payload  []byte        // Payload data to be sent.
+	response chan Response // Channel to receive the response from the server.
 }
 
 // NewCommunicator creates and returns a new Communicator with the specified payload and response channel.
-func NewCommunicator(payload []byte, response chan []byte) Communicator {
+func NewCommunicator(payload []byte, response chan Response) Communicator {
 	return Communicator{
 		payload:  payload,
 		response: response,
@@ -50,7 +51,7 @@ func New() (*Driver, error) {
 		connections[index] = con
 	}
 
-	return &Driver{fnv.New32a(), connections}, nil
+	return &Driver{connections}, nil
 }
 
 // NewConnection creates and returns a new Driver instance with the provided address and number of connections.
@@ -82,9 +83,14 @@ func (d *Connection) Init() error {
 }
 
 // SingleConnection represents an individual network connection and its associated communication channel.
+// Many requests can be in flight on one connection, replies are matched to requests by their id.
 type SingleConnection struct {
-	communicatorCh chan Communicator // Channel for communicating with the Driver.
-	net.Conn                         // The network connection (TCP, etc.).
+	communicatorCh chan Communicator        // Channel for communicating with the Driver.
+	net.Conn                                // The network connection (TCP, etc.).
+	nextID         uint32                   // Id of the last request sent on the connection.
+	pending        map[uint32]chan Response // Requests waiting for a reply, by request id.
+	err            error                    // Set once the connection is broken.
+	sync.Mutex                              // Protects pending and err.
 }
 
 // NewSingleConnection creates and returns a new SingleConnection instance.
@@ -94,78 +100,394 @@ func NewSingleConnection(communicatorCh chan Communicator, addr string) (*Single
 		return nil, err // Return error if the connection fails.
 	}
 
+	return newSingleConnection(communicatorCh, conn), nil
+}
+
+// newSingleConnection wraps an established network connection.
+func newSingleConnection(communicatorCh chan Communicator, conn net.Conn) *SingleConnection {
 	return &SingleConnection{
-		communicatorCh: communicatorCh, // Assign the provided communication channel.
-		Conn:           conn,           // Assign the established network connection.
-	}, nil
+		communicatorCh: communicatorCh,                 // Assign the provided communication channel.
+		Conn:           conn,                           // Assign the established network connection.
+		pending:        make(map[uint32]chan Response), // No request is in flight yet.
+	}
 }
 
-// Worker listens for incoming payloads from the communicator channel and processes them asynchronously.
+// Worker listens for incoming payloads from the communicator channel and writes them to the connection
+// without waiting for the reply, the replies are delivered by Reader.
 func (s *SingleConnection) Worker() {
-	readBuffer := make([]byte, 1024*1024+10) // Buffer for receiving data from the server.
-	for payload := range s.communicatorCh {  // Loop through incoming payloads.
+	go s.Reader()
+
+	for payload := range s.communicatorCh { // Loop through incoming payloads.
+		s.nextID++
+		id := s.nextID
+
+		// Register the request before writing it, the reply can arrive before Write returns.
+		if err := s.register(id, payload.response); err != nil {
+			payload.response <- Response{err: err}
+			return // The connection is broken, leave the payloads to the other connections.
+		}
+
 		// Write the payload to the connection.
-		_, err := s.Conn.Write(payload.payload)
-		if err != nil {
+		p.SetRequestID(payload.payload, id)
+		if _, err := s.Conn.Write(payload.payload); err != nil {
 			log.Println(err) // Log the error if writing fails.
-			continue
+			s.deliver(id, Response{err: err})
 		}
+	}
+}
 
-		// Read the response from the server.
-		n, err := s.Conn.Read(readBuffer)
+// Reader reads response frames from the connection and delivers each one to the request with the same id.
+func (s *SingleConnection) Reader() {
+	bufSize := make([]byte, 4) // Buffer for the length of the response frame.
+
+	for {
+		// Read the response frame from the server.
+		response, err := s.ReadResponse(bufSize)
 		if err != nil {
-			log.Println(err) // Log the error if reading fails.
-			continue
+			if err != io.EOF {
+				log.Println(err) // Log the error if reading fails.
+			}
+
+			s.fail(err)
+			return
 		}
 
-		response := make([]byte, n)
-		copy(response, readBuffer[:n])
 		// Send the received data back through the response channel.
-		payload.response <- response
+		s.deliver(response.id, response)
+	}
+}
+
+// register remembers the response channel of a request that is about to be sent.
+func (s *SingleConnection) register(id uint32, response chan Response) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+
+	s.pending[id] = response
+	return nil
+}
+
+// deliver sends the response to the request waiting for it.
+func (s *SingleConnection) deliver(id uint32, response Response) {
+	s.Lock()
+	ch, ok := s.pending[id]
+	delete(s.pending, id)
+	s.Unlock()
+
+	if !ok {
+		log.Printf("response for unknown request %d", id)
+		return
+	}
+
+	ch <- response
+}
+
+// fail marks the connection as broken and fails every request still waiting for a reply.
+func (s *SingleConnection) fail(err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.err = err
+	for id, ch := range s.pending {
+		ch <- Response{err: err}
+		delete(s.pending, id)
+	}
+}
+
+// ReadResponse reads exactly one length-prefixed response frame from the connection.
+func (s *SingleConnection) ReadResponse(bufSize []byte) (Response, error) {
+	if _, err := io.ReadFull(s.Conn, bufSize); err != nil {
+		return Response{}, err
+	}
+
+	frame := make([]byte, p.DecodeLength(bufSize))
+	if _, err := io.ReadFull(s.Conn, frame); err != nil {
+		return Response{}, err
 	}
+
+	return DecodeResponse(frame)
 }
 
 // SetReq sends a request to set a key-value pair with a TTL (Time-To-Live) on the server.
-func (d *Driver) SetReq(key, value []byte, ttl int) (<-chan []byte, error) {
-	n, err := d.Write(key)
+func (d *Driver) SetReq(key, value []byte, ttl int) (<-chan Response, error) {
+	payload, err := p.Set(key, value, ttl)
+	return d.OperationReq(payload, d.Route(key), err)
+}
+
+// AddReq sends a request to store a key-value pair only if the key is absent.
+// The check and the store are atomic on the server, the response fails with ErrNotStored if the key is present.
+func (d *Driver) AddReq(key, value []byte, ttl int) (<-chan Response, error) {
+	payload, err := p.Add(key, value, ttl)
+	return d.OperationReq(payload, d.Route(key), err)
+}
+
+// ReplaceReq sends a request to store a key-value pair only if the key is present.
+// The check and the store are atomic on the server, the response fails with ErrNotStored if the key is absent.
+func (d *Driver) ReplaceReq(key, value []byte, ttl int) (<-chan Response, error) {
+	payload, err := p.Replace(key, value, ttl)
+	return d.OperationReq(payload, d.Route(key), err)
+}
+
+// AppendReq sends a request to add the value after the value stored under the key.
+// The object keeps its TTL, the response fails with ErrNotStored if the key is absent.
+func (d *Driver) AppendReq(key, value []byte) (<-chan Response, error) {
+	payload, err := p.Append(key, value)
+	return d.OperationReq(payload, d.Route(key), err)
+}
+
+// PrependReq sends a request to add the value before the value stored under the key.
+// The object keeps its TTL, the response fails with ErrNotStored if the key is absent.
+func (d *Driver) PrependReq(key, value []byte) (<-chan Response, error) {
+	payload, err := p.Prepend(key, value)
+	return d.OperationReq(payload, d.Route(key), err)
+}
+
+// GetReq sends a request to get a value by key from the server
+func (d *Driver) GetReq(key []byte) (<-chan Response, error) {
+	payload, err := p.Get(key)
+	return d.OperationReq(payload, d.Route(key), err)
+}
+
+// GetWithCAS gets a value by key together with its CAS value, which CompareAndSet uses
+// to store a new value only if nobody modified the object in between.
+func (d *Driver) GetWithCAS(key []byte) ([]byte, uint64, error) {
+	response, err := d.GetReq(key)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	res := <-response
+	if err := res.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return res.Value, res.CAS, nil
+}
+
+// CompareAndSet sends a request to store a key-value pair only if the object still has the CAS value.
+// The response fails with ErrExists if the object was modified and ErrNotFound if it is gone.
+func (d *Driver) CompareAndSet(key, value []byte, ttl int, cas uint64) (<-chan Response, error) {
+	payload, err := p.CompareAndSet(key, value, ttl, cas)
+	return d.OperationReq(payload, d.Route(key), err)
+}
+
+// IncrementReq sends a request to add the delta to a counter, the addition wraps around at 64 bits.
+// The counter is modified atomically on the server, Response.Number returns its new value.
+func (d *Driver) IncrementReq(key []byte, delta uint64, counter Counter) (<-chan Response, error) {
+	payload, err := p.Increment(key, counter.body(delta), counter.TTL)
+	return d.OperationReq(payload, d.Route(key), err)
+}
+
+// DecrementReq sends a request to subtract the delta from a counter, the counter stops at 0.
+// The counter is modified atomically on the server, Response.Number returns its new value.
+func (d *Driver) DecrementReq(key []byte, delta uint64, counter Counter) (<-chan Response, error) {
+	payload, err := p.Decrement(key, counter.body(delta), counter.TTL)
+	return d.OperationReq(payload, d.Route(key), err)
+}
+
+// body encodes the body of a counter request.
+func (c Counter) body(delta uint64) []byte {
+	format := p.CounterDecimal
+	if c.Binary {
+		format = p.CounterBinary
+	}
+
+	if c.Create {
+		return p.EncodeCounterInitial(delta, format, c.Initial)
+	}
+
+	return p.EncodeCounter(delta, format)
+}
+
+// TouchReq sends a request to update the TTL of an object without reading its value.
+// A TTL of 0 means the object never expires.
+func (d *Driver) TouchReq(key []byte, ttl int) (<-chan Response, error) {
+	payload, err := p.Touch(key, ttl)
+	return d.OperationReq(payload, d.Route(key), err)
+}
+
+// GetAndTouchReq sends a request to get a value by key and update its TTL in one round trip,
+// for sliding expiration. A TTL of 0 means the object never expires.
+func (d *Driver) GetAndTouchReq(key []byte, ttl int) (<-chan Response, error) {
+	payload, err := p.GetAndTouch(key, ttl)
+	return d.OperationReq(payload, d.Route(key), err)
+}
+
+// DeleteReq sends a request to delete a key-value pair from the server.
+func (d *Driver) DeleteReq(key []byte) (<-chan Response, error) {
+	payload, err := p.Delete(key)
+	return d.OperationReq(payload, d.Route(key), err)
+}
+
+// Counter describes how IncrementReq and DecrementReq treat the stored value.
+type Counter struct {
+	Binary  bool   // The value is an 8 byte little endian number instead of decimal digits.
+	Create  bool   // A missing counter is created with the initial value instead of failing with ErrNotFound.
+	Initial uint64 // Value of a created counter.
+	TTL     int    // Time-To-Live in seconds of a created counter.
+}
+
+// Item is an object stored by SetMulti.
+type Item struct {
+	Key   []byte // Key of the object.
+	Value []byte // Value of the object.
+	TTL   int    // Time-To-Live in seconds, 0 means the object never expires.
+	Flags uint32 // Client flags stored with the object.
+}
+
+// batch is a multi key request sent to one server.
+type batch struct {
+	positions []int           // Positions of the request's keys in the caller's list, in the order of the request.
+	response  <-chan Response // Channel to receive the response from the server.
+}
+
+// GetMulti gets many keys at once. The keys are grouped by the server they route to,
+// every server gets one multi get request and the responses are merged by key.
+// A missing key is in the result with the status of the miss.
+func (d *Driver) GetMulti(keys [][]byte) (map[string]Response, error) {
+	batches, err := d.send(keys, func(positions []int) ([]byte, error) {
+		return p.MultiGet(pick(keys, positions))
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	payload, err := p.Set(key, value, ttl)
-	return d.OperationReq(payload, n%len(d.Conn), err)
+	results := make(map[string]Response, len(keys))
+	for _, batch := range batches {
+		response := <-batch.response
+		if err := response.Err(); err != nil {
+			return nil, err
+		}
+
+		entries := response.Value
+		for _, position := range batch.positions {
+			status, flags, cas, value, rest, ok := p.NextEntry(entries)
+			if !ok {
+				return nil, ErrBadResponse
+			}
+
+			results[string(keys[position])] = Response{Status: status, Flags: flags, CAS: cas, Value: value}
+			entries = rest
+		}
+	}
+
+	return results, nil
 }
 
-// GetReq sends a request to get a value by key from the server
-func (d *Driver) GetReq(key []byte) (<-chan []byte, error) {
-	n, err := d.Write(key)
+// SetMulti stores many items at once, every server gets one multi set request with the items that route to it.
+// The request has to fit in the largest slab of the server. The result holds the status of every item by key.
+func (d *Driver) SetMulti(items []Item) (map[string]Response, error) {
+	keys := make([][]byte, len(items))
+	for i, item := range items {
+		keys[i] = item.Key
+	}
+
+	batches, err := d.send(keys, func(positions []int) ([]byte, error) {
+		var body []byte
+		for _, position := range positions {
+			item := items[position]
+
+			var err error
+			if body, err = p.AppendItem(body, item.Key, item.Value, item.TTL, item.Flags); err != nil {
+				return nil, err
+			}
+		}
+
+		return p.MultiSet(body)
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	payload, err := p.Get(key)
-	return d.OperationReq(payload, n%len(d.Conn), err)
+	return collect(keys, batches)
 }
 
-// DeleteReq sends a request to delete a key-value pair from the server.
-func (d *Driver) DeleteReq(key []byte) (<-chan []byte, error) {
-	n, err := d.Write(key)
+// DeleteMulti deletes many keys at once, every server gets one multi delete request with the keys that route to it.
+// The result holds the status of every deletion by key.
+func (d *Driver) DeleteMulti(keys [][]byte) (map[string]Response, error) {
+	batches, err := d.send(keys, func(positions []int) ([]byte, error) {
+		return p.MultiDelete(pick(keys, positions))
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	payload, err := p.Delete(key)
-	return d.OperationReq(payload, n%len(d.Conn), err)
+	return collect(keys, batches)
+}
+
+// send groups the keys by the server they route to and sends every server the request built by encode
+// from the positions of its keys. All requests are sent before any reply is awaited, so the servers work in parallel.
+func (d *Driver) send(keys [][]byte, encode func(positions []int) ([]byte, error)) ([]batch, error) {
+	groups := make(map[int][]int)
+	for position, key := range keys {
+		route := d.Route(key)
+		groups[route] = append(groups[route], position)
+	}
+
+	batches := make([]batch, 0, len(groups))
+	for route, positions := range groups {
+		payload, err := encode(positions)
+		response, err := d.OperationReq(payload, route, err)
+		if err != nil {
+			return nil, err
+		}
+
+		batches = append(batches, batch{positions, response})
+	}
+
+	return batches, nil
+}
+
+// collect merges the replies of multi set and multi delete requests, which hold one status byte per key.
+func collect(keys [][]byte, batches []batch) (map[string]Response, error) {
+	results := make(map[string]Response, len(keys))
+	for _, batch := range batches {
+		response := <-batch.response
+		if err := response.Err(); err != nil {
+			return nil, err
+		}
+
+		if len(response.Value) != len(batch.positions) {
+			return nil, ErrBadResponse
+		}
+
+		for i, position := range batch.positions {
+			results[string(keys[position])] = Response{Status: response.Value[i]}
+		}
+	}
+
+	return results, nil
+}
+
+// pick returns the keys at the positions.
+func pick(keys [][]byte, positions []int) [][]byte {
+	picked := make([][]byte, len(positions))
+	for i, position := range positions {
+		picked[i] = keys[position]
+	}
+
+	return picked
+}
+
+// Route returns the index of the server the key belongs to.
+func (d *Driver) Route(key []byte) int {
+	h := fnv.New32a()
+	h.Write(key)
+
+	return int(h.Sum32() % uint32(len(d.Conn)))
 }
 
 // OperationReq sends the payload request to the Driver's PayloadCh and returns a response channel.
-func (d *Driver) OperationReq(payload []byte, route int, err error) (<-chan []byte, error) {
+func (d *Driver) OperationReq(payload []byte, route int, err error) (<-chan Response, error) {
 	if err != nil {
 		return nil, err // Return error if the operation failed.
 	}
 
 	// Create a new response channel.
-	newResponse := make(chan []byte)
+	newResponse := make(chan Response, 1)
 
 	// Send the payload and response channel to the PayloadCh channel.
 	d.Conn[route].PayloadCh <- NewCommunicator(payload, newResponse)
@@ -0,0 +1,93 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+
+	p "github.com/WatchJani/memCashed/client/parser"
+)
+
+var (
+	ErrNotFound       = errors.New("object not found")
+	ErrExpired        = errors.New("time expire")
+	ErrNotEnoughSpace = errors.New("there is not enough space")
+	ErrTooLarge       = errors.New("payload is too large")
+	ErrUnsupported    = errors.New("operation is not supported")
+	ErrBadResponse    = errors.New("malformed response frame")
+	ErrNotStored      = errors.New("object not stored")
+	ErrExists         = errors.New("object was modified")
+	ErrNonNumeric     = errors.New("stored value is not a number")
+	ErrRejected       = errors.New("object rejected by the admission filter")
+)
+
+// Response is a decoded server reply.
+type Response struct {
+	Status byte   // Status code of the operation.
+	Flags  uint32 // Flags stored with the object.
+	CAS    uint64 // CAS value of the object, changed every time the object is modified.
+	Value  []byte // Stored value, only set when the status is StatusOK.
+	id     uint32 // Id of the request the response belongs to.
+	err    error  // Transport error, set when the request never got a reply.
+}
+
+// DecodeResponse turns a response frame (without the length prefix) into a Response.
+func DecodeResponse(frame []byte) (Response, error) {
+	if len(frame) < 17 {
+		return Response{}, ErrBadResponse
+	}
+
+	status, flags, id, cas, body := p.DecodeResponse(frame)
+	return Response{
+		Status: status,
+		Flags:  flags,
+		CAS:    cas,
+		Value:  body,
+		id:     id,
+	}, nil
+}
+
+// Err converts an unsuccessful status into an error, a successful one returns nil.
+func (r Response) Err() error {
+	if r.err != nil {
+		return r.err
+	}
+
+	switch r.Status {
+	case p.StatusOK, p.StatusStored, p.StatusDeleted, p.StatusTouched:
+		return nil
+	case p.StatusNotFound:
+		return ErrNotFound
+	case p.StatusExpired:
+		return ErrExpired
+	case p.StatusNotEnoughSpace:
+		return ErrNotEnoughSpace
+	case p.StatusTooLarge:
+		return ErrTooLarge
+	case p.StatusUnsupported:
+		return ErrUnsupported
+	case p.StatusNotStored:
+		return ErrNotStored
+	case p.StatusExists:
+		return ErrExists
+	case p.StatusNonNumeric:
+		return ErrNonNumeric
+	case p.StatusRejected:
+		return ErrRejected
+	default:
+		return fmt.Errorf("unknown status %d", r.Status)
+	}
+}
+
+// Number returns the new value of a counter from the response to an increment or decrement request.
+func (r Response) Number() uint64 {
+	if len(r.Value) < 8 {
+		return 0
+	}
+
+	return p.LittleEndianDecode64(r.Value)
+}
+
+// IsHit reports whether the response carries a stored value.
+func (r Response) IsHit() bool {
+	return r.err == nil && r.Status == p.StatusOK
+}
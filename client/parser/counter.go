@@ -0,0 +1,43 @@
+package decoder
+
+// Formats of a number stored as a counter.
+const (
+	CounterDecimal byte = iota // Unsigned decimal digits, like memcached
+	CounterBinary              // 8 byte little endian unsigned integer
+)
+
+// Increment encodes a request that adds the delta from the counter body to a counter.
+// The TTL is used if the request creates the counter.
+func Increment(key, body []byte, ttl int) ([]byte, error) {
+	return Encode('I', key, body, ttl)
+}
+
+// Decrement encodes a request that subtracts the delta from the counter body from a counter, stopping at 0.
+// The TTL is used if the request creates the counter.
+func Decrement(key, body []byte, ttl int) ([]byte, error) {
+	return Encode('X', key, body, ttl)
+}
+
+// counter body
+// delta - 8 byte
+// format - 1 byte (optional, decimal by default)
+// initial value - 8 byte (optional, a missing counter is created with it)
+func EncodeCounter(delta uint64, format byte) []byte {
+	body := make([]byte, 9)
+
+	LittleEndianEncode64(body[0:8], delta)
+	body[8] = format
+
+	return body
+}
+
+// EncodeCounterInitial encodes the body of a counter request that creates a missing counter with the initial value.
+func EncodeCounterInitial(delta uint64, format byte, initial uint64) []byte {
+	body := make([]byte, 17)
+
+	LittleEndianEncode64(body[0:8], delta)
+	body[8] = format
+	LittleEndianEncode64(body[9:17], initial)
+
+	return body
+}
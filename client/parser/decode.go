@@ -8,6 +8,18 @@ func DecodeLength(size []byte) int {
 // key length
 // ttl - 4 byte
 // payload - 4 byte
+// request id - 4 byte
+// flags - 4 byte
 func Decode(payload []byte) (byte, uint32, uint32, uint32) {
 	return payload[0], uint32(payload[1]), LittleEndianDecode(payload[2:6]), LittleEndianDecode(payload[6:10])
 }
+
+// RequestID returns the opaque request id from the request header.
+func RequestID(payload []byte) uint32 {
+	return LittleEndianDecode(payload[10:14])
+}
+
+// Flags returns the client flags from the request header.
+func Flags(payload []byte) uint32 {
+	return LittleEndianDecode(payload[14:18])
+}
@@ -10,21 +10,66 @@ func SetDriver(key, value, reqID []byte, ttl int) ([]byte, error) {
 	return Encode('S', key, value, ttl)
 }
 
+// Add encodes a request that stores the value only if the key is absent.
+func Add(key, value []byte, ttl int) ([]byte, error) {
+	return Encode('A', key, value, ttl)
+}
+
+// Replace encodes a request that stores the value only if the key is present.
+func Replace(key, value []byte, ttl int) ([]byte, error) {
+	return Encode('R', key, value, ttl)
+}
+
+// Append encodes a request that adds the value after the stored value.
+func Append(key, value []byte) ([]byte, error) {
+	return Encode('B', key, value, 0)
+}
+
+// Prepend encodes a request that adds the value before the stored value.
+func Prepend(key, value []byte) ([]byte, error) {
+	return Encode('F', key, value, 0)
+}
+
 func Get(key []byte) ([]byte, error) {
 	return Encode('G', key, EmptyByte, 0)
 }
 
+// Touch encodes a request that updates the TTL of the object.
+func Touch(key []byte, ttl int) ([]byte, error) {
+	return Encode('T', key, EmptyByte, ttl)
+}
+
+// GetAndTouch encodes a request that gets the value and updates the TTL of the object.
+func GetAndTouch(key []byte, ttl int) ([]byte, error) {
+	return Encode('U', key, EmptyByte, ttl)
+}
+
 func Delete(key []byte) ([]byte, error) {
 	return Encode('D', key, EmptyByte, 0)
 }
 
+// CompareAndSet encodes a request that stores the value only if the object still has the CAS value.
+// The CAS value is sent in front of the value.
+func CompareAndSet(key, value []byte, ttl int, cas uint64) ([]byte, error) {
+	body := make([]byte, 8+len(value))
+
+	LittleEndianEncode64(body[0:8], cas)
+	copy(body[8:], value)
+
+	return Encode('C', key, body, ttl)
+}
+
 func Encode(operation byte, key, value []byte, ttl int) ([]byte, error) {
-	payloadSize := uint32(len(value) + len(key) + 10)
+	return EncodeFlags(operation, key, value, ttl, 0)
+}
+
+// EncodeFlags encodes a request that carries client flags, which are stored with the object.
+func EncodeFlags(operation byte, key, value []byte, ttl int, flags uint32) ([]byte, error) {
+	payloadSize := uint32(len(value) + len(key) + 18)
 
 	buf := make([]byte, payloadSize+4)
 	offset := 0
 
-	// payloadSize := uint32(len(value) + len(key) + 10)
 	offset += LittleEndianEncode(buf[offset:offset+4], payloadSize)
 
 	buf[offset] = operation
@@ -42,9 +87,20 @@ func Encode(operation byte, key, value []byte, ttl int) ([]byte, error) {
 	bodyLength := uint32(len(value))
 	offset += LittleEndianEncode(buf[offset:offset+4], bodyLength)
 
+	//request id, stamped by the connection that sends the request
+	offset += LittleEndianEncode(buf[offset:offset+4], 0)
+
+	//client flags
+	offset += LittleEndianEncode(buf[offset:offset+4], flags)
+
 	offset += copy(buf[offset:], key)
 
 	offset += copy(buf[offset:], value)
 
 	return buf, nil
 }
+
+// SetRequestID stamps the opaque request id into an encoded request (including the length prefix).
+func SetRequestID(request []byte, id uint32) {
+	LittleEndianEncode(request[14:18], id)
+}
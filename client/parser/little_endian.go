@@ -15,3 +15,15 @@ func LittleEndianEncode(payload []byte, num uint32) int {
 
 	return 4
 }
+
+func LittleEndianDecode64(payload []byte) uint64 {
+	return uint64(LittleEndianDecode(payload[0:4])) |
+		uint64(LittleEndianDecode(payload[4:8]))<<32
+}
+
+func LittleEndianEncode64(payload []byte, num uint64) int {
+	LittleEndianEncode(payload[0:4], uint32(num))
+	LittleEndianEncode(payload[4:8], uint32(num>>32))
+
+	return 8
+}
@@ -0,0 +1,98 @@
+package decoder
+
+import "errors"
+
+var ErrKeyTooLong = errors.New("key is longer than 255 bytes")
+
+// MultiGet encodes a request that gets every key in one frame.
+func MultiGet(keys [][]byte) ([]byte, error) {
+	body, err := EncodeKeys(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	return Encode('g', EmptyByte, body, 0)
+}
+
+// MultiSet encodes a request that stores every item of a multi set body in one frame.
+func MultiSet(body []byte) ([]byte, error) {
+	return Encode('s', EmptyByte, body, 0)
+}
+
+// MultiDelete encodes a request that deletes every key in one frame.
+func MultiDelete(keys [][]byte) ([]byte, error) {
+	body, err := EncodeKeys(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	return Encode('d', EmptyByte, body, 0)
+}
+
+// multi key body
+// key length - 1 byte
+// key
+// ... repeated for every key
+func EncodeKeys(keys [][]byte) ([]byte, error) {
+	size := len(keys)
+	for _, key := range keys {
+		if len(key) > 255 {
+			return nil, ErrKeyTooLong
+		}
+
+		size += len(key)
+	}
+
+	buf := make([]byte, 0, size)
+	for _, key := range keys {
+		buf = append(buf, uint8(len(key)))
+		buf = append(buf, key...)
+	}
+
+	return buf, nil
+}
+
+// multi set body
+// key length - 1 byte
+// ttl - 4 byte
+// flags - 4 byte
+// value length - 4 byte
+// key
+// value
+// ... repeated for every item
+func AppendItem(buf []byte, key, value []byte, ttl int, flags uint32) ([]byte, error) {
+	if len(key) > 255 {
+		return nil, ErrKeyTooLong
+	}
+
+	header := make([]byte, 13)
+
+	header[0] = uint8(len(key))
+	LittleEndianEncode(header[1:5], uint32(ttl))
+	LittleEndianEncode(header[5:9], flags)
+	LittleEndianEncode(header[9:13], uint32(len(value)))
+
+	return append(append(append(buf, header...), key...), value...), nil
+}
+
+// multi get entry
+// status - 1 byte
+// flags - 4 byte
+// cas - 8 byte
+// value length - 4 byte
+// value
+
+// NextEntry splits the first entry off a multi get body into status, flags, cas, value and the rest of the body.
+// It reports false if the body is malformed.
+func NextEntry(body []byte) (byte, uint32, uint64, []byte, []byte, bool) {
+	if len(body) < 17 {
+		return 0, 0, 0, nil, nil, false
+	}
+
+	end := 17 + int(LittleEndianDecode(body[13:17]))
+	if len(body) < end {
+		return 0, 0, 0, nil, nil, false
+	}
+
+	return body[0], LittleEndianDecode(body[1:5]), LittleEndianDecode64(body[5:13]), body[17:end], body[end:], true
+}
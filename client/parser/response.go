@@ -0,0 +1,52 @@
+package decoder
+
+// Status codes sent in the first byte of every response frame.
+const (
+	StatusOK             byte = iota // Value found, the body holds the stored value
+	StatusStored                     // Object inserted
+	StatusDeleted                    // Object deleted
+	StatusNotFound                   // Object not found
+	StatusExpired                    // Object found, but its TTL has expired
+	StatusNotEnoughSpace             // There is no memory left for the request
+	StatusTooLarge                   // Request does not fit in the largest slab
+	StatusUnsupported                // Operation is not supported
+	StatusExists                     // Object was modified since its CAS value was read
+	StatusNotStored                  // Conditional store was not performed
+	StatusNonNumeric                 // Stored value is not a number
+	StatusTouched                    // TTL of the object updated
+	StatusRejected                   // Admission filter didn't let the object evict a more popular one
+)
+
+// response frame
+// length - 4 byte (size of everything after the length)
+// status - 1 byte
+// flags - 4 byte
+// request id - 4 byte (echoed from the request)
+// cas - 8 byte
+// body
+func EncodeResponse(status byte, flags, id uint32, cas uint64, body []byte) []byte {
+	payloadSize := uint32(len(body) + 17)
+
+	buf := make([]byte, payloadSize+4)
+	offset := 0
+
+	offset += LittleEndianEncode(buf[offset:offset+4], payloadSize)
+
+	buf[offset] = status
+	offset++
+
+	offset += LittleEndianEncode(buf[offset:offset+4], flags)
+
+	offset += LittleEndianEncode(buf[offset:offset+4], id)
+
+	offset += LittleEndianEncode64(buf[offset:offset+8], cas)
+
+	copy(buf[offset:], body)
+
+	return buf
+}
+
+// DecodeResponse splits a response frame (without the length prefix) into status, flags, request id, cas and body.
+func DecodeResponse(payload []byte) (byte, uint32, uint32, uint64, []byte) {
+	return payload[0], LittleEndianDecode(payload[1:5]), LittleEndianDecode(payload[5:9]), LittleEndianDecode64(payload[9:17]), payload[17:]
+}
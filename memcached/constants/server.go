@@ -3,41 +3,142 @@ package constants
 import (
 	"errors"
 	"runtime"
+	"time"
 )
 
 const (
-	SetOperation    = 'S'
-	GetOperation    = 'G'
-	DeleteOperation = 'D'
+	SetOperation           = 'S'
+	GetOperation           = 'G'
+	DeleteOperation        = 'D'
+	AddOperation           = 'A' // Store only if the key is absent
+	ReplaceOperation       = 'R' // Store only if the key is present
+	TouchOperation         = 'T' // Update the TTL without returning the value
+	IncrementOperation     = 'I' // Add the delta from the counter body to a number
+	DecrementOperation     = 'X' // Subtract the delta from the counter body from a number, stopping at 0
+	AppendOperation        = 'B' // Add the body at the back of the stored value
+	PrependOperation       = 'F' // Add the body at the front of the stored value
+	MetaGetOperation       = 'M' // Get the value together with its metadata
+	GetAndTouchOperation   = 'U' // Get the value and update the TTL
+	MultiGetOperation      = 'g' // Get every key listed in the body with one request
+	MultiSetOperation      = 's' // Store every item listed in the body with one request
+	MultiDeleteOperation   = 'd' // Delete every key listed in the body with one request
+	CompareAndSetOperation = 'C' // Store only if the 8 byte CAS value in front of the body still matches
+	PeekOperation          = 'P' // Get the metadata without counting a read or moving the object in the eviction order
 
-	HeaderSize = 10
+	HeaderSize = 18
 	MiB        = 1024 * 1024
 	TCP        = "tcp"
 
 	KiB                       = 1024 // 1 MiB in bytes
 	MinimumNumberOfConnection = 5    // Minimum number of connections to the server
+	DefaultNumberOfShards     = 16   // Default number of partitions of the keys, each with its own lock
 	IntDefaultValue           = 0    // Default value for integers
 	DefaultPort               = 5000 // Default server port
 	BufferSizeTCP             = 4
+
+	ResponseHeaderSize = 17 // status (1 byte) + flags (4 byte) + request id (4 byte) + cas (8 byte)
+
+	MetaSize     = 9   // ttl remaining (4 byte) + seconds since last access (4 byte) + hit before (1 byte)
+	CASSize      = 8   // CAS value in front of the body of a compare and set request
+	EntrySize    = 17  // status (1 byte) + flags (4 byte) + cas (8 byte) + value length (4 byte) of a multi get entry
+	MaxKeyLength = 250 // Longest key accepted by the text protocol
+
+	MaxLineLength = 64 * KiB // Longest command line accepted by the text protocol, a get of a few hundred long keys fits
+
+	ExpireInterval = 100 * time.Millisecond // How often the expirer looks for expired objects
+	ExpireBatch    = 1000                   // Most objects the expirer checks at once, bounding the time it holds the lock
+	ExpireCompact  = 1024                   // Entries the expiry heap of a shard holds before the stale ones are dropped
+
+	PageReclaimInterval = time.Second // How long a page must stay empty before its memory goes back to the OS
+
+	AutomoveInterval = time.Second // How often the page rebalancer compares the eviction pressure of the slabs
+	AutomoveWindows  = 3           // Consecutive checks a slab must evict in before it is given a page
+
+	HotPercent          = 20                    // Share of the objects of a slab kept in the hot segment of its LRU
+	WarmPercent         = 40                    // Share of the objects of a slab kept in the warm segment of its LRU
+	LRUMaintainInterval = 50 * time.Millisecond // How often the LRU maintainer moves objects between segments
+	LRUMaintainBatch    = 1000                  // Most objects the LRU maintainer moves in a slab at once
+
+	PolicyLRU     = "lru"      // Segmented LRU with hot, warm and cold segments
+	PolicyClock   = "clock"    // CLOCK, an LRU approximation with a reference bit per object
+	PolicyLFU     = "lfu"      // Least frequently used
+	PolicyTinyLFU = "wtinylfu" // Window TinyLFU, an LRU window in front of a frequency filtered segmented LRU
+
+	LFUMaxFrequency         = 16      // Highest frequency the LFU policy tells apart
+	TinyLFUWindowPercent    = 1       // Share of the objects of a slab kept in the window of W-TinyLFU
+	TinyLFUProtectedPercent = 80      // Share of the main segments of W-TinyLFU kept in the protected one
+	SketchWidth             = 1 << 14 // Counters in every row of a frequency sketch
+	AdmissionSketchWidth    = 1 << 18 // Counters in every row of the sketch of the admission filter
+
+	DefaultGrowthFactor = 2.0 // Chunk size of every slab class relative to the previous one
+	DefaultMinChunkSize = 128 // Chunk size of the smallest slab class
+	MinimumChunkSize    = 96  // Smallest chunk size accepted for the smallest slab class, it holds the item header
+	ChunkAlign          = 8   // Chunk sizes are rounded up to a multiple of it
+	MaximumSlabClasses  = 64  // Most slab classes generated from a growth factor
+
+	IndexInitialSlots = 1 << 10 // Slots of the hash table of a shard before it grows, a power of two
+	IndexLoadPercent  = 75      // Share of the slots of a hash table in use that makes it grow
+
+	ProtocolBinary = "binary" // Custom binary framing
+	ProtocolText   = "text"   // Memcached ASCII text protocol
+	ProtocolRESP   = "resp"   // Redis serialization protocol (RESP2 and RESP3)
+)
+
+// Formats of a number stored as a counter.
+const (
+	CounterDecimal byte = iota // Unsigned decimal digits, like memcached
+	CounterBinary              // 8 byte little endian unsigned integer
+)
+
+// Status codes sent in the first byte of every response frame.
+const (
+	StatusOK             byte = iota // Value found, the body holds the stored value
+	StatusStored                     // Object inserted
+	StatusDeleted                    // Object deleted
+	StatusNotFound                   // Object not found
+	StatusExpired                    // Object found, but its TTL has expired
+	StatusNotEnoughSpace             // There is no memory left for the request
+	StatusTooLarge                   // Request does not fit in the largest slab
+	StatusUnsupported                // Operation is not supported
+	StatusExists                     // Object was modified since its CAS value was read
+	StatusNotStored                  // Conditional store was not performed
+	StatusNonNumeric                 // Stored value is not a number
+	StatusTouched                    // TTL of the object updated
+	StatusRejected                   // Admission filter didn't let the object evict a more popular one
 )
 
 var (
 	DefaultNumberOfWorkers = runtime.NumCPU() // Default number of worker threads
 
-	ObjectInserted = []byte("object inserted")
-	ObjectDeleted  = []byte("deleted")
-
 	// ErrOperationIsNotSupported is the error returned when an unsupported operation is attempted.
 	ErrOperationIsNotSupported = errors.New("operation is not supported")
 
 	// ErrNotEnoughSpace is the error returned when there is not enough space to allocate memory.
 	ErrNotEnoughSpace = errors.New("there is not enough space")
 
-	// NoReq is a buffer used for reading unprocessed data when memory allocation fails.
-	NoReq             []byte = make([]byte, MiB+HeaderSize) // Buffer to read unprocessed data
-	ErrObjectNotFound        = []byte("object not found")
-	ErrTimeExpire            = []byte("time expire")
+	// ErrPayloadTooLarge is the error returned when a request is bigger than the largest slab.
+	ErrPayloadTooLarge = errors.New("payload is too large")
+
+	// ErrSlabLimit is the error returned when a slab class has used all the memory it is allowed to take.
+	ErrSlabLimit = errors.New("slab class reached its memory limit")
+
+	// ErrRejected is the error returned when the admission filter doesn't let a new object evict.
+	ErrRejected = errors.New("object rejected by the admission filter")
+
+	// ErrHugePages is the error returned when transparent huge pages are enabled on a system without them.
+	ErrHugePages = errors.New("transparent huge pages are not supported")
+
+	// ErrShrink is the error returned when the slabs can't give back enough pages for a lower memory limit.
+	ErrShrink = errors.New("not enough free pages for the new memory limit")
+
+	// ErrUnknownPolicy is the error returned when the configured eviction policy doesn't exist.
+	ErrUnknownPolicy = errors.New("unknown eviction policy")
+
+	// ErrUnknownProtocol is the error returned when a listener is configured with an unknown protocol.
+	ErrUnknownProtocol = errors.New("unknown protocol")
 
 	InfoServerClose     = "server closed"
 	InfoConnectionClose = "connection is close"
+
+	Version = "1.6.0" // Version reported to memcached clients
 )
@@ -1,12 +1,15 @@
 package types
 
 import (
+	"cmp"
 	"fmt"
 	"log"
 	"os"
+	"slices"
 
 	"github.com/WatchJani/memCashed/memcached/constants"
 	"github.com/WatchJani/memCashed/memcached/internal/cli"
+	"github.com/WatchJani/memCashed/memcached/link_list"
 	"github.com/WatchJani/memCashed/memcached/memory_allocator"
 
 	"gopkg.in/yaml.v3"
@@ -18,8 +21,17 @@ import (
 type Config struct {
 	Server         ServerConfig `yaml:"server"`              // Server configuration
 	MemoryAllocate int          `yaml:"memory_for_allocate"` // Amount of memory allocated (default 5GiB)
+	MaxMemory      int          `yaml:"max_memory_limit"`    // Highest memory limit cache_memlimit accepts, in MiB (default memory_for_allocate)
+	HugePages      bool         `yaml:"huge_pages"`          // Backs the memory with transparent huge pages
 	NumberOfWorker int          `yaml:"number_of_worker"`    // Number of worker threads for the server
+	NumberOfShards int          `yaml:"number_of_shards"`    // Number of partitions of the keys, each with its own lock (default 16)
 	DefaultSlab    []CustomSlab `yaml:"custom_slabs"`        // Default slab sizes
+	SlabAutomove   bool         `yaml:"slab_automove"`       // Moves pages from idle slabs to the ones that evict
+	GrowthFactor   float64      `yaml:"growth_factor"`       // Chunk size of a slab class relative to the previous one (default 2)
+	MinChunkSize   int          `yaml:"min_chunk_size"`      // Chunk size of the smallest slab class (default 128)
+	MaxChunkSize   int          `yaml:"max_chunk_size"`      // Chunk size of the largest slab class (default 1 MiB)
+	EvictionPolicy string       `yaml:"eviction_policy"`     // lru, clock, lfu or wtinylfu (default lru)
+	Admission      bool         `yaml:"admission_filter"`    // New objects only evict the ones they are used more often than
 }
 
 // Creates and returns a new instance of the `Config` structure.
@@ -29,14 +41,27 @@ func NewConfig() *Config {
 
 // Server configuration structure, including port and max connection count.
 type ServerConfig struct {
-	Port          int `yaml:"port"`                  // Port the server listens on (default 5001)
-	MaxConnection int `yaml:"max_number_connection"` // Maximum number of connections to the server (default 100)
+	Port          int        `yaml:"port"`                  // Port the server listens on (default 5001)
+	Protocol      string     `yaml:"protocol"`              // Protocol spoken on the port (default binary)
+	MaxConnection int        `yaml:"max_number_connection"` // Maximum number of connections to the server (default 100)
+	Listeners     []Listener `yaml:"listeners"`             // Additional ports, each with its own protocol
+}
+
+// Listener is a port the server listens on and the protocol spoken on it.
+type Listener struct {
+	Port     int    `yaml:"port"`     // Port the listener binds to
+	Protocol string `yaml:"protocol"` // binary, text or resp (default binary)
+}
+
+// Addr returns the listener's port as a formatted string (e.g., ":11211").
+func (l Listener) Addr() string {
+	return fmt.Sprintf(":%d", l.Port)
 }
 
 // Defines slab structures with capacities and maximum memory allocations.
 type CustomSlab struct {
 	Capacity          int `yaml:"chunk_capacity"`      // Capacity of each slab (in bytes)
-	MaxMemoryAllocate int `yaml:"max_allocate_memory"` // Maximum memory that can be allocated to the slab
+	MaxMemoryAllocate int `yaml:"max_allocate_memory"` // Maximum memory that can be allocated to the slab in MiB, 0 for no limit
 }
 
 // Function that loads configuration from a YAML file.
@@ -60,7 +85,8 @@ func LoadConfiguration() *Config {
 }
 
 // Function that returns a memory allocator based on the `MemoryAllocate` value from the configuration.
-func (c *Config) MemoryAllocator() *memory_allocator.Allocator {
+// It returns an error if the memory can't be mapped.
+func (c *Config) MemoryAllocator() (*memory_allocator.Allocator, error) {
 	memorySize := c.MemoryAllocate
 
 	// If no memory size is defined, allocate a minimum of 1 MiB
@@ -72,36 +98,84 @@ func (c *Config) MemoryAllocator() *memory_allocator.Allocator {
 	return memory_allocator.New(memorySize * constants.MiB)
 }
 
-// Returns the default slabs with predefined capacities and maximum memory allocations.
+// MemoryLimit returns the highest memory limit in bytes the cache can be resized to while it runs,
+// the memory allocated at the start if none is set or it is lower.
+func (c *Config) MemoryLimit() int {
+	return max(c.MaxMemory, c.MemoryAllocate, 1) * constants.MiB
+}
+
+// Returns the default slabs, power of two chunk sizes from 128 B to 1 MiB without memory limits.
 func DefaultSlabs() []CustomSlab {
-	return []CustomSlab{
-		{64, 0},
-		{128, 0},
-		{256, 0},
-		{512, 0},
-		{1024, 0},
-		{2048, 0},
-		{4096, 0},
-		{8192, 0},
-		{16384, 0},
-		{32768, 0},
-		{65536, 0},
-		{131072, 0},
-		{262144, 0},
-		{524288, 0},
-		{1048576, 0},
+	return GenerateSlabs(constants.DefaultMinChunkSize, constants.MiB, constants.DefaultGrowthFactor)
+}
+
+// GenerateSlabs returns slab classes from minSize to maxSize, each chunk size growing by factor
+// from the previous one and rounded up to ChunkAlign. The largest class is always maxSize.
+func GenerateSlabs(minSize, maxSize int, factor float64) []CustomSlab {
+	var slabs []CustomSlab
+
+	for size := alignChunk(minSize); size < maxSize && len(slabs) < constants.MaximumSlabClasses-1; {
+		slabs = append(slabs, CustomSlab{Capacity: size})
+
+		// A factor close to 1 still has to grow the chunk
+		size = max(alignChunk(int(float64(size)*factor)), size+constants.ChunkAlign)
+	}
+
+	return append(slabs, CustomSlab{Capacity: maxSize})
+}
+
+// alignChunk rounds the size up to a multiple of ChunkAlign.
+func alignChunk(size int) int {
+	return (size + constants.ChunkAlign - 1) / constants.ChunkAlign * constants.ChunkAlign
+}
+
+// GeneratedSlabs returns the slab classes of the growth_factor, min_chunk_size and max_chunk_size options.
+// Options out of range use their defaults, chunks are never larger than a page.
+func (c *Config) GeneratedSlabs() []CustomSlab {
+	factor := c.GrowthFactor
+	if factor <= 1 {
+		factor = constants.DefaultGrowthFactor
+	}
+
+	maxSize := c.MaxChunkSize
+	if maxSize < 1 || maxSize > constants.MiB {
+		maxSize = constants.MiB
+	}
+
+	minSize := c.MinChunkSize
+	if minSize < 1 {
+		minSize = constants.DefaultMinChunkSize
 	}
+
+	minSize = min(max(minSize, constants.MinimumChunkSize), maxSize)
+
+	return GenerateSlabs(minSize, maxSize, factor)
 }
 
 // Configures and returns a list of slabs based on the current configuration and memory allocator.
 func (c *Config) Slabs(allocator *memory_allocator.Allocator) []memory_allocator.Slab {
-	slabs := c.DefaultSlab
+	slabs := slices.Clone(c.DefaultSlab)
 
-	// If no slabs are defined in the configuration, use the default slabs.
+	// If no slabs are defined in the configuration, generate them from the growth factor.
 	if len(c.DefaultSlab) == constants.IntDefaultValue {
-		slabs = DefaultSlabs()
+		slabs = c.GeneratedSlabs()
 	}
 
+	// A chunk smaller than the item header can't hold an object, it is rounded up to the smallest size
+	for i := range slabs {
+		slabs[i].Capacity = max(slabs[i].Capacity, constants.MinimumChunkSize)
+	}
+
+	// The slab manager looks up the class of a request by binary search, smallest chunks first
+	slices.SortStableFunc(slabs, func(a, b CustomSlab) int {
+		return cmp.Compare(a.Capacity, b.Capacity)
+	})
+
+	// Classes rounded up to the same size are one class, the first one keeps its memory limit
+	slabs = slices.CompactFunc(slabs, func(a, b CustomSlab) bool {
+		return a.Capacity == b.Capacity
+	})
+
 	// Allocate memory for slabs based on the specified configuration.
 	slabAllocator := make([]memory_allocator.Slab, len(slabs))
 	for i := range slabAllocator {
@@ -112,6 +186,11 @@ func (c *Config) Slabs(allocator *memory_allocator.Allocator) []memory_allocator
 	return slabAllocator // Return the configured slabs
 }
 
+// Policy returns the constructor of the configured eviction policy.
+func (c *Config) Policy() (func() link_list.Policy, error) {
+	return link_list.NewPolicy(c.EvictionPolicy)
+}
+
 // Returns the maximum number of connections, ensuring it meets the minimum required value.
 func (c *Config) MaxConnection() int {
 	maxConnection := c.Server.MaxConnection
@@ -124,14 +203,25 @@ func (c *Config) MaxConnection() int {
 	return maxConnection // Return the maximum number of connections
 }
 
-// Returns the server's port as a formatted string, using the default port if the configured port is invalid.
-func (c *Config) Port() string {
+// Returns every listener of the server, starting with the main port, with the protocol defaulting to binary.
+func (c *Config) Listeners() ([]Listener, error) {
 	port := c.Server.Port
 	if port < 1 {
 		port = constants.DefaultPort //Default port
 	}
 
-	return fmt.Sprintf(":%d", port) // Format the port as a string (e.g., ":5001")
+	listeners := append([]Listener{{port, c.Server.Protocol}}, c.Server.Listeners...)
+	for i := range listeners {
+		switch listeners[i].Protocol {
+		case "":
+			listeners[i].Protocol = constants.ProtocolBinary
+		case constants.ProtocolBinary, constants.ProtocolText, constants.ProtocolRESP:
+		default:
+			return nil, fmt.Errorf("%w: %q on port %d", constants.ErrUnknownProtocol, listeners[i].Protocol, listeners[i].Port)
+		}
+	}
+
+	return listeners, nil
 }
 
 func (c *Config) NumberWorker() int {
@@ -142,3 +232,13 @@ func (c *Config) NumberWorker() int {
 
 	return numberOfWorker
 }
+
+// NumberShards returns the number of partitions the keys are split into, the default if it isn't set.
+func (c *Config) NumberShards() int {
+	numberOfShards := c.NumberOfShards
+	if numberOfShards < 1 {
+		numberOfShards = constants.DefaultNumberOfShards
+	}
+
+	return numberOfShards
+}
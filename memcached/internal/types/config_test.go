@@ -0,0 +1,86 @@
+package types
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/WatchJani/memCashed/memcached/constants"
+	"github.com/WatchJani/memCashed/memcached/memory_allocator"
+)
+
+// capacities returns the chunk sizes of the slabs.
+func capacities(slabs []CustomSlab) []int {
+	sizes := make([]int, len(slabs))
+	for i, slab := range slabs {
+		sizes[i] = slab.Capacity
+	}
+
+	return sizes
+}
+
+func TestGenerateSlabs(t *testing.T) {
+	// without options the classes are the powers of two from 128 B to 1 MiB
+	defaults := capacities(NewConfig().GeneratedSlabs())
+	if len(defaults) != 14 || defaults[0] != 128 || defaults[1] != 256 || defaults[13] != constants.MiB {
+		t.Errorf("default: expected powers of two from 128 to %d | get %v", constants.MiB, defaults)
+	}
+
+	config := &Config{GrowthFactor: 1.25, MinChunkSize: 96, MaxChunkSize: 1000}
+	if sizes, expected := capacities(config.GeneratedSlabs()), []int{96, 120, 152, 192, 240, 304, 384, 480, 600, 752, 944, 1000}; !slices.Equal(sizes, expected) {
+		t.Errorf("factor 1.25: expected %v | get %v", expected, sizes)
+	}
+
+	// a factor too small to grow a chunk still adds ChunkAlign, and the number of classes is limited
+	config = &Config{GrowthFactor: 1.01, MinChunkSize: 1}
+	sizes := capacities(config.GeneratedSlabs())
+	if len(sizes) != constants.MaximumSlabClasses || sizes[0] != constants.MinimumChunkSize || sizes[1] != constants.MinimumChunkSize+constants.ChunkAlign || sizes[len(sizes)-1] != constants.MiB {
+		t.Errorf("factor 1.01: expected %d classes from %d to %d | get %v", constants.MaximumSlabClasses, constants.MinimumChunkSize, constants.MiB, sizes)
+	}
+}
+
+func TestSlabsSorted(t *testing.T) {
+	config := &Config{DefaultSlab: []CustomSlab{{1000, 0}, {100, 2}, {300, 0}}}
+
+	allocator, err := memory_allocator.New(constants.MiB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manager := memory_allocator.NewSlabManager(config.Slabs(allocator), 1)
+	// chunk sizes are rounded up to a multiple of ChunkAlign
+	for i, expected := range []int{104, 304, 1000} {
+		if size := manager.SlabStats()[i].ChunkSize; size != expected {
+			t.Errorf("class %d: expected chunk size %d | get %d", i, expected, size)
+		}
+	}
+
+	if limit := manager.SlabStats()[0].MaxPages; limit != 2 {
+		t.Errorf("limit: expected the class to keep its limit of 2 pages | get %d", limit)
+	}
+}
+
+func TestSlabsMinimumChunk(t *testing.T) {
+	config := &Config{DefaultSlab: []CustomSlab{{64, 1}, {1000, 0}, {constants.MinimumChunkSize, 0}}}
+
+	allocator, err := memory_allocator.New(constants.MiB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a chunk smaller than the item header is rounded up and merged with the class of that size
+	stats := memory_allocator.NewSlabManager(config.Slabs(allocator), 1).SlabStats()
+	if len(stats) != 2 || stats[0].ChunkSize != constants.MinimumChunkSize || stats[0].MaxPages != 1 || stats[1].ChunkSize != 1000 {
+		t.Errorf("expected classes of %d (1 page) and 1000 | get %+v", constants.MinimumChunkSize, stats)
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	if _, err := (&Config{EvictionPolicy: constants.PolicyTinyLFU}).Policy(); err != nil {
+		t.Errorf("wtinylfu: %v", err)
+	}
+
+	if _, err := (&Config{EvictionPolicy: "fifo"}).Policy(); !errors.Is(err, constants.ErrUnknownPolicy) {
+		t.Errorf("unknown policy: expected %v | get %v", constants.ErrUnknownPolicy, err)
+	}
+}
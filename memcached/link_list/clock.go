@@ -0,0 +1,45 @@
+package link_list
+
+// Clock evicts the first node without its reference bit, going around the nodes from the oldest.
+// A read only sets the reference bit, the hand clears it and gives the node another round.
+type Clock struct {
+	lists // A single list, the hand is at its end
+}
+
+// NewClock creates an empty CLOCK policy.
+func NewClock() *Clock {
+	return &Clock{lists: newLists(1)}
+}
+
+// Inset adds the node of a new object just behind the hand.
+func (c *Clock) Inset(node *Node, hash uint64) {
+	c.Lock()
+	defer c.Unlock()
+
+	node.reset(hash)
+	c.insert(node, 0)
+}
+
+// Read sets the reference bit of the node.
+func (c *Clock) Read(node *Node) {
+	node.active.Store(true)
+}
+
+// LastNode moves the hand to the first node without its reference bit and returns it.
+// If every node was referenced, the hand goes around once and returns the node it started at.
+func (c *Clock) LastNode() *Node {
+	c.Lock()
+	defer c.Unlock()
+
+	ring := &c.all[0]
+	for range ring.length {
+		node := ring.last
+		if !node.active.Swap(false) {
+			return node
+		}
+
+		c.move(node, 0) // The node gets another round
+	}
+
+	return ring.last
+}
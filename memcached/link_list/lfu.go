@@ -0,0 +1,68 @@
+package link_list
+
+import (
+	"github.com/WatchJani/memCashed/memcached/constants"
+)
+
+// LFU evicts the least frequently used node, the least recently used one among nodes read as often.
+// Every frequency has its own list, nodes read more than LFUMaxFrequency times share the last one.
+type LFU struct {
+	lists // The list of a node is its frequency minus one
+}
+
+// NewLFU creates an empty LFU policy.
+func NewLFU() *LFU {
+	return &LFU{lists: newLists(constants.LFUMaxFrequency)}
+}
+
+// Inset adds the node of a new object, used once.
+func (l *LFU) Inset(node *Node, hash uint64) {
+	l.Lock()
+	defer l.Unlock()
+
+	node.reset(hash)
+	l.insert(node, 0)
+}
+
+// Read moves the node to the list of the next frequency.
+func (l *LFU) Read(node *Node) {
+	l.Lock()
+	defer l.Unlock()
+
+	if !l.has(node) {
+		return // The node was removed in the meantime
+	}
+
+	l.move(node, min(int(node.segment)+1, len(l.all)-1))
+}
+
+// LastNode returns the least recently used node of the lowest frequency.
+func (l *LFU) LastNode() *Node {
+	l.Lock()
+	defer l.Unlock()
+
+	for segment := range l.all {
+		if last := l.all[segment].last; last != nil {
+			return last
+		}
+	}
+
+	return nil
+}
+
+// Collect returns the nodes that match, the most frequently used first.
+func (l *LFU) Collect(match func(*Node) bool) []*Node {
+	l.Lock()
+	defer l.Unlock()
+
+	var nodes []*Node
+	for segment := len(l.all) - 1; segment >= 0; segment-- {
+		for current := l.all[segment].root; current != nil; current = current.next() {
+			if match(current) {
+				nodes = append(nodes, current)
+			}
+		}
+	}
+
+	return nodes
+}
@@ -3,6 +3,7 @@ package link_list
 import (
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"unsafe"
 )
 
@@ -10,73 +11,90 @@ import (
 type DLL struct {
 	root         *Node // Pointer to the first (root) node in the list.
 	last         *Node // Pointer to the last node in the list.
+	length       int   // Number of nodes in the list.
 	sync.RWMutex       // Read-Write lock to ensure safe concurrent access.
 }
 
-// Node represents a node in the doubly linked list.
+// Node represents a node in the doubly linked list. It is the start of the header of a stored object,
+// in the object's own memory. The links are offsets from the node to its neighbours, so the lists hold
+// no pointers the GC has to follow.
 type Node struct {
-	left  *Node // Pointer to the previous node in the list.
-	right *Node // Pointer to the next node in the list.
-	value Value // Value stored in the node.
+	left    int64       // Offset of the previous node in the list, 0 for none.
+	right   int64       // Offset of the next node in the list, 0 for none.
+	hash    uint64      // Hash of the object's key.
+	active  atomic.Bool // Set when the node is read, cleared when a segmented LRU moves it.
+	segment uint8       // Segment of a segmented LRU holding the node.
 }
 
-// GetKey returns the key of the value stored in the node.
-func (n *Node) GetKey() string {
-	return n.value.key // Return the key of the node's value.
+// Hash returns the hash of the key of the object the node belongs to.
+func (n *Node) Hash() uint64 {
+	return n.hash
 }
 
-func (n *Node) GetPointer() unsafe.Pointer {
-	return n.value.pointer
-}
+// at returns the node at the offset from n, nil for the offset 0.
+func (n *Node) at(offset int64) *Node {
+	if offset == 0 {
+		return nil
+	}
 
-// Value represents the data in a node, containing a pointer and a key.
-type Value struct {
-	pointer unsafe.Pointer // Pointer to the actual data, storing memory location.
-	key     string         // Key used to link to the hash table.
+	return (*Node)(unsafe.Add(unsafe.Pointer(n), offset))
 }
 
-// NewValue creates a new Value with the provided pointer and key.
-func NewValue(pointer unsafe.Pointer, key string) Value {
-	return Value{
-		pointer: pointer, // Assign pointer to memory location.
-		key:     key,     // Assign key to link the data.
+// offset returns the offset of the other node from n, 0 for nil.
+func (n *Node) offset(other *Node) int64 {
+	if other == nil {
+		return 0
 	}
+
+	return int64(uintptr(unsafe.Pointer(other)) - uintptr(unsafe.Pointer(n)))
+}
+
+// prev returns the previous node in the list.
+func (n *Node) prev() *Node {
+	return n.at(n.left)
 }
 
-// GetLRUFreeSpace returns a slice of bytes representing the free space in the LRU node.
-// It locks the list to avoid concurrent modification during the operation.
-func (dll *DLL) GetLRUFreeSpace(lru *Node, blockSize int) []byte {
-	dll.Lock()         // Lock the DLL to ensure thread-safe access.
-	defer dll.Unlock() // Unlock the DLL after the function completes.
+// next returns the next node in the list.
+func (n *Node) next() *Node {
+	return n.at(n.right)
+}
+
+// link sets the neighbours of the node.
+func (n *Node) link(left, right *Node) {
+	n.left, n.right = n.offset(left), n.offset(right)
+}
 
-	ptr := lru.value.pointer // Get the pointer to the LRU node's data.
-	// Return a slice of bytes with the size of blockSize starting from the pointer.
-	return unsafe.Slice((*byte)(ptr), blockSize)
+// reset prepares the node of a newly stored object, not in any list yet.
+func (n *Node) reset(hash uint64) {
+	n.left, n.right = 0, 0
+	n.hash = hash
+	n.active.Store(false)
 }
 
-// Inset adds a new node with the given value to the doubly linked list.
+// Inset adds the node of an object with the given key hash to the doubly linked list.
 // It locks the DLL to prevent race conditions while modifying the list.
-func (dll *DLL) Inset(value Value) *Node {
+func (dll *DLL) Inset(node *Node, hash uint64) {
 	dll.Lock()         // Lock the DLL to ensure thread-safe modifications.
 	defer dll.Unlock() // Unlock the DLL after the operation.
 
-	// Create a new node and get the current root of the list.
-	newNode, root := &Node{}, dll.root
+	node.reset(hash)
+	dll.push(node)
+}
+
+// push inserts the node before the root of the list. The caller must hold the lock.
+func (dll *DLL) push(node *Node) {
+	node.link(nil, dll.root)
 
 	// If the list is not empty, insert the new node before the root.
 	if dll.root != nil {
-		newNode.right = root // New node's right points to the current root.
-		root.left = newNode  // Current root's left points to the new node.
+		dll.root.left = dll.root.offset(node) // Current root's left points to the new node.
 	} else { // If the list is empty, the new node becomes both the root and the last node.
-		dll.last = newNode
+		dll.last = node
 	}
 
-	// Set the value of the new node.
-	newNode.value = value
 	// Update the root to the new node.
-	dll.root = newNode
-
-	return newNode // Return the newly inserted node.
+	dll.root = node
+	dll.length++
 }
 
 // Delete removes a given node from the doubly linked list.
@@ -89,22 +107,59 @@ func (dll *DLL) Delete(node *Node) {
 	dll.Lock()         // Lock the DLL to ensure safe modification.
 	defer dll.Unlock() // Unlock the DLL after the operation.
 
-	// If the node has a left neighbor, update its right pointer to skip the node.
-	if node.left != nil {
-		node.left.right = node.right
+	dll.unlink(node)
+}
+
+// has reports whether the node is in the list, a node that was removed has no neighbours and isn't the root.
+// The caller must hold the lock.
+func (dll *DLL) has(node *Node) bool {
+	return node.left != 0 || node == dll.root
+}
+
+// unlink removes the node from the list. The caller must hold the lock.
+func (dll *DLL) unlink(node *Node) {
+	if !dll.has(node) {
+		return
+	}
+
+	left, right := node.prev(), node.next()
+
+	// If the node has a left neighbor, update its right link to skip the node.
+	if left != nil {
+		left.right = left.offset(right)
 	} else { // If the node is the root, update the root pointer.
-		dll.root = node.right
+		dll.root = right
 	}
 
-	// If the node has a right neighbor, update its left pointer to skip the node.
-	if node.right != nil {
-		node.right.left = node.left
+	// If the node has a right neighbor, update its left link to skip the node.
+	if right != nil {
+		right.left = right.offset(left)
 	} else { // If the node is the last node, update the last pointer.
-		dll.last = node.left
+		dll.last = left
 	}
 
-	node.left = nil
-	node.right = nil
+	node.left, node.right = 0, 0
+	dll.length--
+}
+
+// replace puts the node at to in place of the node in the list. The caller must hold the lock.
+func (dll *DLL) replace(node, to *Node) {
+	left, right := node.prev(), node.next()
+	to.link(left, right)
+
+	if left != nil {
+		left.right = left.offset(to)
+	} else {
+		dll.root = to
+	}
+
+	if right != nil {
+		right.left = right.offset(to)
+	} else {
+		dll.last = to
+	}
+
+	node.left, node.right = 0, 0
 }
 
 // Remove deletes the last node from the doubly linked list.
@@ -117,16 +172,7 @@ func (dll *DLL) Remove() {
 		return // If the list is empty, there's nothing to remove.
 	}
 
-	// If the last node has no left neighbor, make the list empty.
-	if dll.last.left == nil {
-		dll.last = nil
-		dll.root = nil
-		return
-	}
-
-	// Otherwise, update the last pointer to the previous node.
-	dll.last = dll.last.left
-	dll.last.right = nil // Set the new last node's right pointer to nil.
+	dll.unlink(dll.last)
 }
 
 // LastNode returns the last node in the doubly linked list.
@@ -140,38 +186,53 @@ func (dll *DLL) Read(node *Node) {
 	dll.Lock()         // Lock the DLL to ensure safe modification.
 	defer dll.Unlock() // Unlock the DLL after the operation.
 
-	// If the node is already the root, there's nothing to do.
-	if node == dll.root {
+	// If the node is already the root, or it was removed from the list, there's nothing to do.
+	if node == dll.root || node.left == 0 {
 		return
 	}
 
-	// Remove the node from its current position.
-	node.left.right = node.right
-	node.right.left = node.left
+	// Remove the node from its current position and insert it at the front (make it the new root).
+	dll.unlink(node)
+	dll.push(node)
+}
 
-	// Insert the node at the front (make it the new root).
-	node.right = dll.root
-	node.left = nil
+// Collect returns the nodes that match, from the most to the least recently used.
+func (dll *DLL) Collect(match func(*Node) bool) []*Node {
+	dll.RLock()
+	defer dll.RUnlock()
 
-	// Update the current root's left pointer to point to the node.
-	dll.root.left = node
+	var nodes []*Node
+	for current := dll.root; current != nil; current = current.next() {
+		if match(current) {
+			nodes = append(nodes, current)
+		}
+	}
 
-	// Set the root to the node.
-	dll.root = node
+	return nodes
+}
+
+// Relocate puts the node at to, where the memory of its object was copied, keeping its place in the list.
+func (dll *DLL) Relocate(node, to *Node) {
+	dll.Lock()
+	defer dll.Unlock()
+
+	if dll.has(node) {
+		dll.replace(node, to)
+	}
 }
 
-// ReadAll traverses the entire doubly linked list from root to last, printing each node's value.
+// ReadAll traverses the entire doubly linked list from root to last, printing the key hash of each node.
 func (dll *DLL) ReadAll() {
 	// Traverse the list starting from the root.
-	for root := dll.root; root != nil; root = root.right {
-		fmt.Println(root.value) // Print the value of the current node.
+	for root := dll.root; root != nil; root = root.next() {
+		fmt.Println(root.hash) // Print the key hash of the current node.
 	}
 }
 
-// ReadBack traverses the entire doubly linked list from last to root, printing each node's value.
+// ReadBack traverses the entire doubly linked list from last to root, printing the key hash of each node.
 func (dll *DLL) ReadBack() {
 	// Traverse the list starting from the last node.
-	for current := dll.last; current != nil; current = current.left {
-		fmt.Println(current.value) // Print the value of the current node.
+	for current := dll.last; current != nil; current = current.prev() {
+		fmt.Println(current.hash) // Print the key hash of the current node.
 	}
 }
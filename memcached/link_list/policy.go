@@ -0,0 +1,136 @@
+package link_list
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/WatchJani/memCashed/memcached/constants"
+)
+
+// Policy decides which object of a slab class is evicted. Inset is called when an object is stored,
+// Read when it is accessed, Delete when it is removed, and LastNode picks the object to evict next.
+type Policy interface {
+	Inset(node *Node, hash uint64)          // Adds the node of a stored object with the given key hash
+	Read(node *Node)                        // Records an access of the object
+	Delete(node *Node)                      // Removes the node, a node that was already removed is ignored
+	LastNode() *Node                        // Returns the node to evict, nil if there are none
+	Collect(match func(*Node) bool) []*Node // Returns the nodes that match, the ones to evict last first
+	Relocate(node, to *Node)                // Puts the node at the new location of its object, in the same place
+	Len() int                               // Returns the number of nodes
+}
+
+// Maintained is a policy with background work, done by the LRU maintainer.
+type Maintained interface {
+	Maintain(budget int) (warmed, cooled int) // Moves at most budget nodes, returns how many became warm and cold
+}
+
+// NewPolicy returns the constructor of the eviction policy with the given name, the segmented LRU by default.
+func NewPolicy(name string) (func() Policy, error) {
+	switch name {
+	case "", constants.PolicyLRU:
+		return func() Policy { return NewSegmented() }, nil
+	case constants.PolicyClock:
+		return func() Policy { return NewClock() }, nil
+	case constants.PolicyLFU:
+		return func() Policy { return NewLFU() }, nil
+	case constants.PolicyTinyLFU:
+		return func() Policy { return NewTinyLFU() }, nil
+	}
+
+	return nil, fmt.Errorf("%w: %q", constants.ErrUnknownPolicy, name)
+}
+
+// lists are the lists of a policy behind one lock, the segment of a node is the index of its list.
+type lists struct {
+	all        []DLL // Lists of the policy, their own locks are not used
+	sync.Mutex       // Protects all lists
+}
+
+// newLists creates a policy's lists.
+func newLists(n int) lists {
+	return lists{all: make([]DLL, n)}
+}
+
+// has reports whether the node is still in its list. The caller must hold the lock.
+func (l *lists) has(node *Node) bool {
+	return l.all[node.segment].has(node)
+}
+
+// insert puts the node at the front of the list. The caller must hold the lock.
+func (l *lists) insert(node *Node, segment int) {
+	node.segment = uint8(segment)
+	l.all[segment].push(node)
+}
+
+// move takes the node out of its list and puts it at the front of the given one.
+// The caller must hold the lock.
+func (l *lists) move(node *Node, segment int) {
+	l.all[node.segment].unlink(node)
+	l.insert(node, segment)
+}
+
+// Delete removes the node from its list, a node that was already removed is ignored.
+func (l *lists) Delete(node *Node) {
+	if node == nil {
+		return
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	l.all[node.segment].unlink(node)
+}
+
+// Collect returns the nodes that match, list by list from the front.
+func (l *lists) Collect(match func(*Node) bool) []*Node {
+	l.Lock()
+	defer l.Unlock()
+
+	var nodes []*Node
+	for segment := range l.all {
+		for current := l.all[segment].root; current != nil; current = current.next() {
+			if match(current) {
+				nodes = append(nodes, current)
+			}
+		}
+	}
+
+	return nodes
+}
+
+// Relocate puts the node at to, where the memory of its object was copied, keeping its place in the lists.
+func (l *lists) Relocate(node, to *Node) {
+	l.Lock()
+	defer l.Unlock()
+
+	l.all[node.segment].Relocate(node, to)
+}
+
+// Len returns the number of nodes in all lists.
+func (l *lists) Len() int {
+	l.Lock()
+	defer l.Unlock()
+
+	return l.length()
+}
+
+// length returns the number of nodes in all lists. The caller must hold the lock.
+func (l *lists) length() int {
+	total := 0
+	for segment := range l.all {
+		total += l.all[segment].length
+	}
+
+	return total
+}
+
+// last returns the last node of the first list that isn't empty. The caller must hold the lock.
+func (l *lists) last(segments ...int) *Node {
+	for _, segment := range segments {
+		if last := l.all[segment].last; last != nil {
+			return last
+		}
+	}
+
+	return nil
+}
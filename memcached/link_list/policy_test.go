@@ -0,0 +1,147 @@
+package link_list
+
+import (
+	"errors"
+	"fmt"
+	"hash/maphash"
+	"testing"
+
+	"github.com/WatchJani/memCashed/memcached/constants"
+)
+
+func TestNewPolicy(t *testing.T) {
+	for _, name := range []string{"", constants.PolicyLRU, constants.PolicyClock, constants.PolicyLFU, constants.PolicyTinyLFU} {
+		newPolicy, err := NewPolicy(name)
+		if err != nil {
+			t.Fatalf("%q: %v", name, err)
+		}
+
+		// every policy evicts a node it holds, and nothing once it is empty
+		policy := newPolicy()
+		nodes := fill(policy, 3)
+		policy.Read(nodes[1])
+
+		victim := policy.LastNode()
+		if victim == nil || policy.Len() != 3 {
+			t.Fatalf("%q: expected a victim out of 3 nodes | get %v of %d", name, victim, policy.Len())
+		}
+
+		for _, node := range nodes {
+			policy.Delete(node)
+		}
+
+		if policy.LastNode() != nil || policy.Len() != 0 {
+			t.Errorf("%q: expected no nodes after deleting them all | get %d", name, policy.Len())
+		}
+	}
+
+	if _, err := NewPolicy("random"); !errors.Is(err, constants.ErrUnknownPolicy) {
+		t.Errorf("unknown policy: expected %v | get %v", constants.ErrUnknownPolicy, err)
+	}
+}
+
+func TestClock(t *testing.T) {
+	clock := NewClock()
+	nodes := fill(clock, 3)
+
+	// the referenced oldest node gets another round, the next one is evicted
+	clock.Read(nodes[0])
+	if victim := clock.LastNode(); victim != nodes[1] {
+		t.Errorf("victim: expected %d | get %d", nodes[1].Hash(), victim.Hash())
+	}
+
+	// with every node referenced the hand goes around once
+	for _, node := range nodes {
+		clock.Read(node)
+	}
+
+	if victim := clock.LastNode(); victim != nodes[1] {
+		t.Errorf("all referenced: expected %d | get %d", nodes[1].Hash(), victim.Hash())
+	}
+}
+
+func TestLFU(t *testing.T) {
+	lfu := NewLFU()
+	nodes := fill(lfu, 3)
+
+	lfu.Read(nodes[0])
+	lfu.Read(nodes[0])
+	lfu.Read(nodes[1])
+
+	// the node never read is evicted, then the one read least
+	if victim := lfu.LastNode(); victim != nodes[2] {
+		t.Errorf("victim: expected %d | get %d", nodes[2].Hash(), victim.Hash())
+	}
+
+	lfu.Delete(nodes[2])
+	if victim := lfu.LastNode(); victim != nodes[1] {
+		t.Errorf("next victim: expected %d | get %d", nodes[1].Hash(), victim.Hash())
+	}
+
+	// frequencies stop at the last list, and reading a removed node is ignored
+	for range 2 * constants.LFUMaxFrequency {
+		lfu.Read(nodes[0])
+		lfu.Read(nodes[2])
+	}
+
+	if nodes[0].segment != constants.LFUMaxFrequency-1 || lfu.Len() != 2 {
+		t.Errorf("saturated: expected frequency list %d and 2 nodes | get %d and %d", constants.LFUMaxFrequency-1, nodes[0].segment, lfu.Len())
+	}
+}
+
+func TestTinyLFU(t *testing.T) {
+	tiny := NewTinyLFU()
+
+	memory, seed := make([]Node, 1205), maphash.MakeSeed()
+
+	// store evicts once the policy holds more than 200 nodes, like a full slab
+	store := func(key string) *Node {
+		node := &memory[0]
+		memory = memory[1:]
+
+		tiny.Inset(node, maphash.String(seed, key))
+		if tiny.Len() > 200 {
+			tiny.Delete(tiny.LastNode())
+		}
+
+		return node
+	}
+
+	popular := make([]*Node, 200)
+	for i := range popular {
+		popular[i] = store(fmt.Sprint("popular-", i))
+	}
+
+	for range 5 {
+		for _, node := range popular {
+			tiny.Read(node)
+		}
+	}
+
+	// the slab fills up, the window starts to compete for the main segments
+	for i := range 5 {
+		store(fmt.Sprint("filler-", i))
+	}
+
+	kept := 0
+	for _, node := range popular {
+		if tiny.has(node) {
+			kept++
+		}
+	}
+
+	// a scan of keys stored once loses against the popular objects
+	for i := range 1000 {
+		store(fmt.Sprint("scan-", i))
+	}
+
+	for _, node := range popular {
+		if tiny.has(node) {
+			kept--
+		}
+	}
+
+	if kept != 0 || tiny.Len() != 200 {
+		t.Errorf("scan: expected no popular object evicted and 200 nodes | get %d evicted and %d nodes", kept, tiny.Len())
+	}
+}
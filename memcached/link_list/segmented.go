@@ -0,0 +1,99 @@
+package link_list
+
+import (
+	"github.com/WatchJani/memCashed/memcached/constants"
+)
+
+// Segments of a segmented LRU.
+const (
+	Hot      = iota // Objects stored recently
+	Warm            // Objects read again after they were stored
+	Cold            // Objects that weren't read, evicted first
+	segments        // Number of segments
+)
+
+// Segmented is an LRU split into hot, warm and cold segments. A read only sets the active bit of its
+// node, the maintainer moves nodes between the segments by their active bits, so reads never modify
+// the lists. New nodes start hot, and the least recently moved cold node is evicted first.
+type Segmented struct {
+	lists
+}
+
+// NewSegmented creates an empty segmented LRU.
+func NewSegmented() *Segmented {
+	return &Segmented{lists: newLists(segments)}
+}
+
+// Inset adds the node of a new object to the front of the hot segment.
+func (l *Segmented) Inset(node *Node, hash uint64) {
+	l.Lock()
+	defer l.Unlock()
+
+	node.reset(hash)
+	l.insert(node, Hot)
+}
+
+// LastNode returns the node to evict: the last cold node, or the last warm or hot one if there is none.
+func (l *Segmented) LastNode() *Node {
+	l.Lock()
+	defer l.Unlock()
+
+	return l.last(Cold, Warm, Hot)
+}
+
+// Read marks the node as active, the maintainer keeps it in memory the next time it looks at it.
+func (l *Segmented) Read(node *Node) {
+	node.active.Store(true)
+}
+
+// Segments returns the number of nodes in every segment.
+func (l *Segmented) Segments() (hot, warm, cold int) {
+	l.Lock()
+	defer l.Unlock()
+
+	return l.all[Hot].length, l.all[Warm].length, l.all[Cold].length
+}
+
+// Maintain moves at most budget nodes between the segments. Hot and warm nodes over the share of their
+// segment leave from its end: active ones go to the front of the warm segment, the others become cold.
+// Active nodes at the end of the cold segment go back to warm, so they aren't evicted.
+// It returns the number of nodes that became warm and cold.
+func (l *Segmented) Maintain(budget int) (warmed, cooled int) {
+	l.Lock()
+	defer l.Unlock()
+
+	total := l.length()
+
+	for _, segment := range []int{Hot, Warm} {
+		limit := total * constants.HotPercent / 100
+		if segment == Warm {
+			limit = total * constants.WarmPercent / 100
+		}
+
+		for ; budget > 0 && l.all[segment].length > limit; budget-- {
+			node := l.all[segment].last
+			if node.active.Swap(false) {
+				if segment == Hot {
+					warmed++
+				}
+
+				l.move(node, Warm)
+			} else {
+				l.move(node, Cold)
+				cooled++
+			}
+		}
+	}
+
+	for node := l.all[Cold].last; node != nil && budget > 0; budget-- {
+		left := node.prev()
+		if node.active.Swap(false) {
+			l.move(node, Warm)
+			warmed++
+		}
+
+		node = left
+	}
+
+	return warmed, cooled
+}
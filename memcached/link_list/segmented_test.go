@@ -0,0 +1,76 @@
+package link_list
+
+import (
+	"testing"
+)
+
+// fill inserts n nodes into the policy, hashed by their position. The nodes are in one block of memory,
+// like the objects of a slab.
+func fill(l Policy, n int) []*Node {
+	memory := make([]Node, n)
+
+	nodes := make([]*Node, n)
+	for i := range nodes {
+		nodes[i] = &memory[i]
+		l.Inset(nodes[i], uint64(i+1))
+	}
+
+	return nodes
+}
+
+func TestSegmentedMaintain(t *testing.T) {
+	l := NewSegmented()
+	nodes := fill(l, 10)
+
+	// the two newest stay hot, the oldest node read while hot becomes warm, the rest cold
+	l.Read(nodes[0])
+	if warmed, cooled := l.Maintain(100); warmed != 1 || cooled != 7 {
+		t.Errorf("maintain: expected 1 warmed and 7 cooled | get %d and %d", warmed, cooled)
+	}
+
+	if hot, warm, cold := l.Segments(); hot != 2 || warm != 1 || cold != 7 {
+		t.Errorf("segments: expected 2 hot, 1 warm, 7 cold | get %d, %d, %d", hot, warm, cold)
+	}
+
+	// cold nodes are evicted oldest first, a cold node read again is rescued to warm
+	if last := l.LastNode(); last != nodes[1] {
+		t.Errorf("last node: expected %d | get %d", nodes[1].Hash(), last.Hash())
+	}
+
+	l.Read(nodes[1])
+	l.Maintain(100)
+
+	if last := l.LastNode(); last != nodes[2] {
+		t.Errorf("last node after read: expected %d | get %d", nodes[2].Hash(), last.Hash())
+	}
+
+	// the rescued node joins the warm segment, which is still under its share of 4 nodes
+	if hot, warm, cold := l.Segments(); hot != 2 || warm != 2 || cold != 6 {
+		t.Errorf("segments after read: expected 2 hot, 2 warm, 6 cold | get %d, %d, %d", hot, warm, cold)
+	}
+
+	for _, node := range nodes {
+		l.Delete(node)
+		l.Delete(node) // a removed node is ignored
+	}
+
+	if hot, warm, cold := l.Segments(); hot+warm+cold != 0 || l.LastNode() != nil {
+		t.Errorf("empty: expected no nodes | get %d, %d, %d", hot, warm, cold)
+	}
+}
+
+func TestSegmentedLastNode(t *testing.T) {
+	l := NewSegmented()
+	nodes := fill(l, 3)
+
+	// without cold nodes the oldest hot node is evicted
+	if last := l.LastNode(); last != nodes[0] {
+		t.Errorf("last node: expected %d | get %d", nodes[0].Hash(), last.Hash())
+	}
+
+	// reads only set the access bit, the order doesn't change until the maintainer runs
+	l.Read(nodes[0])
+	if last := l.LastNode(); last != nodes[0] {
+		t.Errorf("last node after read: expected %d | get %d", nodes[0].Hash(), last.Hash())
+	}
+}
@@ -0,0 +1,102 @@
+package link_list
+
+import (
+	"github.com/WatchJani/memCashed/memcached/constants"
+	"github.com/WatchJani/memCashed/memcached/sketch"
+)
+
+// Segments of W-TinyLFU, in the order Collect returns them.
+const (
+	protected    = iota // Main objects read again while on probation
+	window              // Objects stored recently
+	probation           // Main objects that weren't read again, evicted first
+	tinySegments        // Number of segments
+)
+
+// TinyLFU is the W-TinyLFU policy: new nodes enter a small LRU window, and leave it only if a frequency
+// sketch estimates them more popular than the object the main segmented LRU would evict instead.
+// A scan of keys read once passes through the window without flushing the main segments.
+type TinyLFU struct {
+	lists
+	sketch *sketch.Sketch // How often every key hash was stored or read, protected by the lock
+	full   bool           // Set at the first eviction, until then the window overflows into probation
+}
+
+// NewTinyLFU creates an empty W-TinyLFU policy.
+func NewTinyLFU() *TinyLFU {
+	return &TinyLFU{
+		lists:  newLists(tinySegments),
+		sketch: sketch.New(constants.SketchWidth),
+	}
+}
+
+// Inset adds the node of a new object to the front of the window. Until the slab is full
+// there is room for every object, so the nodes over the share of the window go on probation.
+func (t *TinyLFU) Inset(node *Node, hash uint64) {
+	t.Lock()
+	defer t.Unlock()
+
+	node.reset(hash)
+	t.insert(node, window)
+	t.sketch.AddHash(hash)
+
+	for !t.full && t.all[window].length > t.windowLimit() {
+		t.move(t.all[window].last, probation)
+	}
+}
+
+// windowLimit returns the number of nodes the window keeps. The caller must hold the lock.
+func (t *TinyLFU) windowLimit() int {
+	return max(t.length()*constants.TinyLFUWindowPercent/100, 1)
+}
+
+// Read counts the access and moves the node to the front of its segment, a node on probation is
+// protected from now on. The protected segment gives its last node back to probation when it is full.
+func (t *TinyLFU) Read(node *Node) {
+	t.Lock()
+	defer t.Unlock()
+
+	if !t.has(node) {
+		return // The node was removed in the meantime
+	}
+
+	t.sketch.AddHash(node.hash)
+
+	if node.segment != probation {
+		t.move(node, int(node.segment))
+		return
+	}
+
+	t.move(node, protected)
+
+	main := t.all[probation].length + t.all[protected].length
+	if t.all[protected].length > main*constants.TinyLFUProtectedPercent/100 {
+		t.move(t.all[protected].last, probation)
+	}
+}
+
+// LastNode returns the node to evict. While the window is over its share, its last node competes with
+// the main victim: the more frequent one stays, and a winning window node goes on probation.
+// Without a main victim the window node is admitted without competing.
+func (t *TinyLFU) LastNode() *Node {
+	t.Lock()
+	defer t.Unlock()
+
+	t.full = true
+	for t.all[window].length > t.windowLimit() {
+		candidate, victim := t.all[window].last, t.last(probation, protected)
+		if victim == nil {
+			t.move(candidate, probation)
+			continue
+		}
+
+		if t.sketch.EstimateHash(candidate.hash) > t.sketch.EstimateHash(victim.hash) {
+			t.move(candidate, probation)
+			return victim
+		}
+
+		return candidate
+	}
+
+	return t.last(probation, protected, window)
+}
@@ -0,0 +1,91 @@
+package memory_allocator
+
+import (
+	"sync"
+
+	"github.com/WatchJani/memCashed/memcached/constants"
+	"github.com/WatchJani/memCashed/memcached/sketch"
+)
+
+// Admission is the filter in front of eviction: a new object may only evict the object the policy
+// picked if it is estimated to be used more often. Keys read once, like the ones of a scan,
+// don't push out the objects that are read again and again.
+type Admission struct {
+	parts []admissionPart // Sketches of the key hashes, split like the keys of the shards
+}
+
+// admissionPart is the sketch of the keys of one shard, with a lock of its own so
+// reads of different shards don't wait for each other.
+type admissionPart struct {
+	sketch     *sketch.Sketch // How often every key hash was read or stored, aged by the sketch itself
+	sync.Mutex                // Protects the sketch
+}
+
+// NewAdmission creates an admission filter that hasn't seen any key, split into the given number of parts.
+// The parts share the counters of one sketch of AdmissionSketchWidth.
+func NewAdmission(parts int) *Admission {
+	a := &Admission{parts: make([]admissionPart, max(parts, 1))}
+	for i := range a.parts {
+		a.parts[i].sketch = sketch.New(constants.AdmissionSketchWidth / len(a.parts))
+	}
+
+	return a
+}
+
+// part returns the part counting the key with the given hash.
+func (a *Admission) part(hash uint64) *admissionPart {
+	return &a.parts[partition(hash, len(a.parts))]
+}
+
+// Record counts an access of the key with the given hash.
+func (a *Admission) Record(hash uint64) {
+	part := a.part(hash)
+	part.Lock()
+	defer part.Unlock()
+
+	part.sketch.AddHash(hash)
+}
+
+// Admit counts the store of the key with the given hash and reports whether it is used more often
+// than the victim, given by the hash of its key.
+func (a *Admission) Admit(hash, victim uint64) bool {
+	part := a.part(hash)
+	part.Lock()
+	part.sketch.AddHash(hash)
+	estimate := part.sketch.EstimateHash(hash)
+	part.Unlock()
+
+	return estimate > a.estimate(victim)
+}
+
+// estimate returns how often the key with the given hash was read or stored.
+func (a *Admission) estimate(hash uint64) uint8 {
+	part := a.part(hash)
+	part.Lock()
+	defer part.Unlock()
+
+	return part.sketch.EstimateHash(hash)
+}
+
+// EnableAdmission puts an admission filter in front of the eviction of new objects.
+// It must be called before the slab manager handles any request.
+func (s *SlabManager) EnableAdmission() {
+	s.admission = NewAdmission(len(s.shards))
+}
+
+// record counts an access of the key with the given hash, if the admission filter is enabled.
+func (s *SlabManager) record(hash uint64) {
+	if s.admission != nil {
+		s.admission.Record(hash)
+	}
+}
+
+// NeedsAdmission checks if the operation stores an object that has to pass the admission filter to evict.
+func NeedsAdmission(operation byte) bool {
+	switch operation {
+	case constants.SetOperation, constants.AddOperation, constants.ReplaceOperation, constants.CompareAndSetOperation:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,119 @@
+package memory_allocator
+
+import (
+	"container/heap"
+	"slices"
+	"time"
+	"unsafe"
+
+	"github.com/WatchJani/memCashed/memcached/constants"
+)
+
+// expiry is an object scheduled to expire.
+type expiry struct {
+	deadline int64  // TTL of the object when it was scheduled, in unix nanoseconds
+	hash     uint64 // Hash of the key of the object
+	ref      uint64 // Offset of the object in the arena, an object stored again since has a new one
+}
+
+// expiryHeap is a min-heap of scheduled objects, ordered by expiration time.
+type expiryHeap []expiry
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].deadline < h[j].deadline }
+func (h expiryHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *expiryHeap) Push(x any) {
+	*h = append(*h, x.(expiry))
+}
+
+func (h *expiryHeap) Pop() any {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
+
+// schedule adds the object to the expirer's heap, an object without TTL never expires.
+// Objects stored again or touched leave stale entries behind, once the heap holds twice as many
+// entries as the shard has objects they are dropped. The caller must hold the shard lock.
+func (s *Shard) schedule(item *Item) {
+	deadline := item.expiry.Load()
+	if deadline == 0 {
+		return
+	}
+
+	heap.Push(&s.expiries, expiry{deadline: deadline, hash: item.Hash(), ref: s.arena.ref(unsafe.Pointer(item))})
+
+	if len(s.expiries) > max(2*s.index.count, constants.ExpireCompact) {
+		s.compact()
+	}
+}
+
+// compact drops the entries of the heap whose objects were stored again, touched or removed since.
+// The caller must hold the shard lock.
+func (s *Shard) compact() {
+	s.expiries = slices.DeleteFunc(s.expiries, func(entry expiry) bool {
+		return s.scheduled(entry) == nil
+	})
+
+	heap.Init(&s.expiries)
+}
+
+// scheduled returns the object of the entry, nil if the entry is stale. The caller must hold the shard lock.
+func (s *Shard) scheduled(entry expiry) *Item {
+	// The chunk may hold another object, or a request, once the object is gone from the index
+	if !s.index.has(entry.hash, entry.ref) {
+		return nil
+	}
+
+	item := s.index.item(entry.ref)
+	if item.expiry.Load() != entry.deadline {
+		return nil // A newer entry exists for the object, or it doesn't expire anymore
+	}
+
+	return item
+}
+
+// Expirer removes expired objects in the background, so objects that are never read again
+// don't keep their memory. It checks at most ExpireBatch objects every ExpireInterval.
+func (s *SlabManager) Expirer() {
+	ticker := time.NewTicker(constants.ExpireInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		s.Expire(now, constants.ExpireBatch)
+	}
+}
+
+// Expire removes objects that expired before now, it checks at most budget entries of the heap of every shard.
+// Entries of objects that were stored again, touched or removed since they were scheduled are dropped.
+// It returns the number of objects removed.
+func (s *SlabManager) Expire(now time.Time, budget int) int {
+	removed := 0
+	for i := range s.shards {
+		removed += s.shards[i].expire(now, budget)
+	}
+
+	return removed
+}
+
+// expire removes the objects of the shard that expired before now, it checks at most budget entries of its heap.
+func (s *Shard) expire(now time.Time, budget int) int {
+	s.Lock()
+	defer s.Unlock()
+
+	removed := 0
+	for ; budget > 0 && len(s.expiries) > 0 && s.expiries[0].deadline <= now.UnixNano(); budget-- {
+		item := s.scheduled(heap.Pop(&s.expiries).(expiry))
+		if item == nil {
+			continue
+		}
+
+		s.drop(item)
+		removed++
+	}
+
+	s.stats.Reclaimed.Add(uint64(removed))
+	return removed
+}
@@ -0,0 +1,89 @@
+package memory_allocator
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/WatchJani/memCashed/memcached/constants"
+	"github.com/WatchJani/memCashed/memcached/parser"
+)
+
+func TestExpire(t *testing.T) {
+	sm := newTestManager()
+	writer := &bytes.Buffer{}
+
+	for _, object := range []struct {
+		key string
+		ttl int
+	}{
+		{"first", 1}, {"second", 1}, {"third", 1}, {"touched", 1}, {"long", 100}, {"forever", 0},
+	} {
+		set, _ := parser.Set([]byte(object.key), []byte("value"), object.ttl)
+		request(t, sm, set, writer)
+		response(t, writer)
+	}
+
+	touch, _ := parser.Encode(constants.TouchOperation, []byte("touched"), nil, 100)
+	request(t, sm, touch, writer)
+	response(t, writer)
+
+	expired := make(map[*Item]bool)
+	for _, key := range []string{"first", "second", "third"} {
+		value, _ := stored(sm, key)
+		expired[value] = true
+	}
+
+	later := time.Now().Add(2 * time.Second)
+
+	// The budget bounds the work of one pass, the rest is left for the next one
+	if removed := sm.Expire(later, 2); removed != 2 {
+		t.Errorf("first pass: expected 2 removed | get %d", removed)
+	}
+
+	if removed := sm.Expire(later, constants.ExpireBatch); removed != 1 {
+		t.Errorf("second pass: expected 1 removed | get %d", removed)
+	}
+
+	for _, key := range []string{"first", "second", "third"} {
+		if _, isFound := stored(sm, key); isFound {
+			t.Errorf("%s: expected to be expired", key)
+		}
+	}
+
+	if items := sm.Stats().CurrItems.Load(); items != 3 {
+		t.Errorf("current items: expected 3 | get %d", items)
+	}
+
+	// The chunks of the expired objects are the next ones handed out
+	for range expired {
+		if block, _, _ := sm.GetSlab(20); !expired[itemOf(block)] {
+			t.Error("chunk of an expired object was not freed")
+		}
+	}
+}
+
+func TestExpiryHeapBounded(t *testing.T) {
+	sm := newTestManager()
+	writer := &bytes.Buffer{}
+
+	set, _ := parser.Set([]byte("key"), []byte("value"), 100)
+	request(t, sm, set, writer)
+	response(t, writer)
+
+	// Every touch leaves the entry of the previous TTL behind in the heap
+	touch, _ := parser.Encode(constants.TouchOperation, []byte("key"), nil, 100)
+	for range 10 * constants.ExpireCompact {
+		request(t, sm, touch, writer)
+		response(t, writer)
+	}
+
+	if entries := len(sm.shards[0].expiries); entries > constants.ExpireCompact {
+		t.Errorf("heap: expected at most %d entries | get %d", constants.ExpireCompact, entries)
+	}
+
+	// The object is still found by the expirer through its latest entry
+	if removed := sm.Expire(time.Now().Add(200*time.Second), constants.ExpireBatch); removed != 1 {
+		t.Errorf("expire: expected 1 removed | get %d", removed)
+	}
+}
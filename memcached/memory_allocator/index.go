@@ -0,0 +1,188 @@
+package memory_allocator
+
+import (
+	"bytes"
+	"unsafe"
+
+	"github.com/WatchJani/memCashed/memcached/constants"
+)
+
+// slot is a bucket of the index: the hash of a key and where its object is in the arena.
+type slot struct {
+	hash uint64 // Hash of the key, 0 for an empty slot
+	ref  uint64 // Offset of the object's header from the start of the arena
+}
+
+// index is the hash table of a shard, open addressing with linear probing. The slots are in a region
+// of memory outside the Go heap and the keys are compared in the chunks of their objects, so the table
+// holds no pointers the GC has to follow, however many objects it holds.
+type index struct {
+	slots []slot     // Slots of the table, their number is a power of two
+	count int        // Number of objects in the table
+	arena *Allocator // Memory the objects are in
+}
+
+// slotSize is the size of a slot in bytes.
+const slotSize = int(unsafe.Sizeof(slot{}))
+
+// newIndex creates an empty index of objects in the arena. The slots count against the limit of the arena.
+func newIndex(arena *Allocator) index {
+	arena.growIndex(constants.IndexInitialSlots * slotSize)
+	return index{slots: newSlots(constants.IndexInitialSlots), arena: arena}
+}
+
+// newSlots returns n empty slots in a region of their own. If the region can't be mapped, the slots
+// come from the Go heap, they hold no pointers so the GC still never scans them.
+func newSlots(n int) []slot {
+	region, err := newRegion(n * slotSize)
+	if err != nil {
+		region = make([]byte, n*slotSize)
+	}
+
+	return unsafe.Slice((*slot)(unsafe.Pointer(unsafe.SliceData(region))), n)
+}
+
+// freeSlots gives the region of slots returned by newSlots back.
+func freeSlots(slots []slot) {
+	freeRegion(unsafe.Slice((*byte)(unsafe.Pointer(unsafe.SliceData(slots))), len(slots)*slotSize))
+}
+
+// item returns the object at the offset from the start of the arena.
+func (x *index) item(ref uint64) *Item {
+	return (*Item)(x.arena.pointer(ref))
+}
+
+// probe returns the position of the first slot to look at for the hash.
+func (x *index) probe(hash uint64) int {
+	return int(hash & uint64(len(x.slots)-1))
+}
+
+// next returns the position of the slot after the one at position.
+func (x *index) next(position int) int {
+	return (position + 1) & (len(x.slots) - 1)
+}
+
+// find returns the object stored under the key with the given hash, nil if there is none.
+func (x *index) find(hash uint64, key string) *Item {
+	for i := x.probe(hash); x.slots[i].hash != 0; i = x.next(i) {
+		if x.slots[i].hash != hash {
+			continue
+		}
+
+		if item := x.item(x.slots[i].ref); string(item.Key()) == key {
+			return item
+		}
+	}
+
+	return nil
+}
+
+// position returns the position of the slot of the object at the offset ref, stored under a key with
+// the given hash, -1 if the object isn't in the table. The header of an object that was removed may
+// already belong to another one, so it isn't read.
+func (x *index) position(hash, ref uint64) int {
+	for i := x.probe(hash); x.slots[i].hash != 0; i = x.next(i) {
+		if x.slots[i].hash == hash && x.slots[i].ref == ref {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// locate returns the position of the slot of an object in the table.
+func (x *index) locate(item *Item) int {
+	return x.position(item.Hash(), x.arena.ref(unsafe.Pointer(item)))
+}
+
+// has reports whether the object at the offset ref, stored under a key with the given hash, is in the table.
+func (x *index) has(hash, ref uint64) bool {
+	return x.position(hash, ref) != -1
+}
+
+// put adds the object to the table and returns the object it replaced under the same key, nil if there was none.
+func (x *index) put(item *Item) *Item {
+	hash, key, ref := item.Hash(), item.Key(), x.arena.ref(unsafe.Pointer(item))
+
+	i := x.probe(hash)
+	for ; x.slots[i].hash != 0; i = x.next(i) {
+		if x.slots[i].hash != hash {
+			continue
+		}
+
+		if previous := x.item(x.slots[i].ref); bytes.Equal(previous.Key(), key) {
+			x.slots[i].ref = ref
+			return previous
+		}
+	}
+
+	x.slots[i] = slot{hash: hash, ref: ref}
+	if x.count++; x.count*100 > len(x.slots)*constants.IndexLoadPercent {
+		x.grow()
+	}
+
+	return nil
+}
+
+// replace puts the object at to, where the memory of the object in the table was copied, in its place.
+func (x *index) replace(item, to *Item) {
+	if i := x.locate(item); i != -1 {
+		x.slots[i].ref = x.arena.ref(unsafe.Pointer(to))
+	}
+}
+
+// remove deletes the object from the table and reports whether it was there. The slots behind it move
+// back into the gap when it is closer to the slot of their hash, so no probe stops at it too early.
+// The header of the object must still be its own, like the header of an object its shard holds.
+func (x *index) remove(item *Item) bool {
+	gap := x.locate(item)
+	if gap == -1 {
+		return false
+	}
+
+	for i := x.next(gap); x.slots[i].hash != 0; i = x.next(i) {
+		home := x.probe(x.slots[i].hash)
+
+		// The slot stays if its home is in the cyclic range after the gap up to it
+		if (i-home)&(len(x.slots)-1) < (i-gap)&(len(x.slots)-1) {
+			continue
+		}
+
+		x.slots[gap], gap = x.slots[i], i
+	}
+
+	x.slots[gap] = slot{}
+	x.count--
+	return true
+}
+
+// grow doubles the number of slots and adds the objects again.
+func (x *index) grow() {
+	old := x.slots
+	x.slots = newSlots(2 * len(old))
+	x.arena.growIndex(len(old) * slotSize)
+
+	for _, entry := range old {
+		if entry.hash == 0 {
+			continue
+		}
+
+		i := x.probe(entry.hash)
+		for x.slots[i].hash != 0 {
+			i = x.next(i)
+		}
+
+		x.slots[i] = entry
+	}
+
+	freeSlots(old)
+}
+
+// each calls fn for every object in the table, until it returns false.
+func (x *index) each(fn func(*Item) bool) {
+	for _, entry := range x.slots {
+		if entry.hash != 0 && !fn(x.item(entry.ref)) {
+			return
+		}
+	}
+}
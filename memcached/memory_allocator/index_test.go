@@ -0,0 +1,72 @@
+package memory_allocator
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"unsafe"
+
+	"github.com/WatchJani/memCashed/memcached/constants"
+	"github.com/WatchJani/memCashed/memcached/parser"
+)
+
+func TestIndex(t *testing.T) {
+	sm := newTestManager()
+	writer := &bytes.Buffer{}
+
+	// more objects than the initial slots allow, the table grows on the way
+	objects := 2 * constants.IndexInitialSlots
+	for i := range objects {
+		set, _ := parser.Set(fmt.Appendf(nil, "key-%d", i), fmt.Appendf(nil, "%d", i), 0)
+		request(t, sm, set, writer)
+		response(t, writer)
+	}
+
+	index := &sm.shards[0].index
+	if index.count != objects || len(index.slots) <= constants.IndexInitialSlots {
+		t.Fatalf("expected %d objects in more than %d slots | get %d in %d", objects, constants.IndexInitialSlots, index.count, len(index.slots))
+	}
+
+	// removing every other object shifts the slots behind them back, the rest are still found
+	for i := 0; i < objects; i += 2 {
+		del, _ := parser.Delete(fmt.Appendf(nil, "key-%d", i))
+		request(t, sm, del, writer)
+		response(t, writer)
+	}
+
+	for i := range objects {
+		value, isFound := stored(sm, fmt.Sprint("key-", i))
+		if isFound != (i%2 == 1) || isFound && string(value.Value()) != fmt.Sprint(i) {
+			t.Errorf("key-%d: found %t", i, isFound)
+		}
+	}
+
+	// the table refers to the objects by their offset in the arena, it holds no pointers
+	if index.count != objects/2 || unsafe.Sizeof(slot{}) != 16 {
+		t.Errorf("expected %d objects in 16 byte slots | get %d in %d byte slots", objects/2, index.count, unsafe.Sizeof(slot{}))
+	}
+}
+
+func TestIndexCountsAgainstLimit(t *testing.T) {
+	// an arena of exactly one block, the hash table of the shard leaves no room for it
+	arena, err := New(constants.MiB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sm := NewSlabManager([]Slab{NewSlab(128, 0, arena)}, 1)
+	if hash := sm.HashBytes(); hash != constants.IndexInitialSlots*slotSize {
+		t.Errorf("hash bytes: expected %d | get %d", constants.IndexInitialSlots*slotSize, hash)
+	}
+
+	if _, err := arena.AllocateBlock(); err != constants.ErrNotEnoughSpace {
+		t.Errorf("block: expected %v | get %v", constants.ErrNotEnoughSpace, err)
+	}
+
+	// a table that grows counts its new slots
+	index := &sm.shards[0].index
+	index.grow()
+	if hash := sm.HashBytes(); hash != len(index.slots)*slotSize {
+		t.Errorf("hash bytes after growing: expected %d | get %d", len(index.slots)*slotSize, hash)
+	}
+}
@@ -0,0 +1,144 @@
+package memory_allocator
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/WatchJani/memCashed/memcached/constants"
+	"github.com/WatchJani/memCashed/memcached/link_list"
+)
+
+// ItemSize is the size of the header in front of every stored object, the key starts behind it.
+const ItemSize = int(unsafe.Sizeof(Item{}))
+
+// frameOffset is the offset of a request in its chunk. The request frame ends where the header does,
+// so its key is already where the key of the object goes, and its header is overwritten by the item header.
+const frameOffset = ItemSize - constants.HeaderSize
+
+// Item is the header of a stored object, at the start of its chunk, followed by the key and the value.
+// It holds everything known about the object, so no operation keeps a copy of it on the Go heap.
+// The header holds no pointers, the GC never looks at the objects.
+//
+// While a caller holds a reference, the chunk isn't reused and the key, the value, the flags and the CAS
+// value of the object don't change: an object read by a response is modified by storing a new one.
+type Item struct {
+	link_list.Node               // Prev and next offsets in the eviction policy of its slab class, and the hash of its key
+	expiry         atomic.Int64  // Unix time in nanoseconds the object expires at, 0 if it never does
+	cas            uint64        // Unique value, changed every time the object is modified
+	access         atomic.Uint32 // Unix time of the last access
+	flags          uint32        // Client flags stored with the object
+	size           uint32        // Length of the value
+	refs           atomic.Int32  // References to the object: one of the index while it holds it, one of every response reading it
+	fetched        atomic.Bool   // Set once the object has been read
+	keySize        uint8         // Length of the key
+	class          uint8         // Index of the slab class holding the object
+}
+
+// itemOf returns the header of the chunk holding the request frame.
+func itemOf(payload []byte) *Item {
+	return (*Item)(unsafe.Add(unsafe.Pointer(unsafe.SliceData(payload)), -frameOffset))
+}
+
+// data returns the memory of the chunk behind the header, n bytes of it.
+func (i *Item) data(n int) []byte {
+	return unsafe.Slice((*byte)(unsafe.Add(unsafe.Pointer(i), ItemSize)), n)
+}
+
+// Key returns the key of the object, in its chunk.
+func (i *Item) Key() []byte {
+	return i.data(int(i.keySize))
+}
+
+// Value returns the value of the object, in its chunk.
+func (i *Item) Value() []byte {
+	return i.data(int(i.keySize) + int(i.size))[i.keySize:]
+}
+
+// Flags returns the client flags stored with the object.
+func (i *Item) Flags() uint32 {
+	return i.flags
+}
+
+// CAS returns the unique value of the current version of the object.
+func (i *Item) CAS() uint64 {
+	return i.cas
+}
+
+// chunk returns the memory of the object, size bytes from the start of its header.
+func (i *Item) chunk(size int) []byte {
+	return unsafe.Slice((*byte)(unsafe.Pointer(i)), size)
+}
+
+// TTL returns the time the object expires at, the zero time if it never does.
+func (i *Item) TTL() time.Time {
+	expiry := i.expiry.Load()
+	if expiry == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, expiry)
+}
+
+// expires sets the time the object expires at, the zero time for never.
+func (i *Item) expires(ttl time.Time) {
+	expiry := int64(0)
+	if !ttl.IsZero() {
+		expiry = ttl.UnixNano()
+	}
+
+	i.expiry.Store(expiry)
+}
+
+// IsExpired checks if the TTL of the object has passed.
+func (i *Item) IsExpired() bool {
+	ttl := i.TTL()
+	return !ttl.IsZero() && time.Now().After(ttl)
+}
+
+// TTLRemaining returns the number of seconds until the object expires, or -1 if it never does.
+func (i *Item) TTLRemaining() int64 {
+	ttl := i.TTL()
+	if ttl.IsZero() {
+		return -1
+	}
+
+	return max(int64(math.Ceil(time.Until(ttl).Seconds())), 0)
+}
+
+// Access records a read of the object and returns the unix time of the previous access
+// and whether the object had been read before.
+func (i *Item) Access() (int64, bool) {
+	return int64(i.access.Swap(uint32(time.Now().Unix()))), i.fetched.Swap(true)
+}
+
+// accessed returns the unix time of the last access and whether the object has been read, without recording a read.
+func (i *Item) accessed() (int64, bool) {
+	return int64(i.access.Load()), i.fetched.Load()
+}
+
+// acquire takes a reference to the object, so its chunk stays as it is after the shard lock is released.
+// The caller must hold the lock of the shard holding the object, at least for reading.
+func (i *Item) acquire() *Item {
+	i.refs.Add(1)
+	return i
+}
+
+// isShared reports whether a response still reads the object, besides the reference of the index.
+// The caller must hold the lock of the shard holding the object.
+func (i *Item) isShared() bool {
+	return i.refs.Load() > 1
+}
+
+// unref drops a reference to the object, the last one gives its chunk back to the slab class holding it.
+func (s *SlabManager) unref(item *Item) {
+	if item.refs.Add(-1) == 0 {
+		s.slabs[item.class].Free(unsafe.Pointer(item))
+	}
+}
+
+// space returns the memory of the object's chunk behind its header, where its key and value are.
+func (s *SlabManager) space(item *Item) []byte {
+	return item.data(s.slabs[item.class].slabSize - ItemSize)
+}
@@ -0,0 +1,64 @@
+package memory_allocator
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/WatchJani/memCashed/memcached/constants"
+	"github.com/WatchJani/memCashed/memcached/parser"
+)
+
+func TestItemHeader(t *testing.T) {
+	sm := newTestManager()
+	writer := &bytes.Buffer{}
+
+	set, _ := parser.EncodeFlags(constants.SetOperation, []byte("key"), []byte("value"), 100, 7)
+	request(t, sm, set, writer)
+	response(t, writer)
+
+	item, _ := stored(sm, "key")
+	if string(item.Key()) != "key" || string(item.Value()) != "value" || item.Flags() != 7 || item.CAS() == 0 || item.TTLRemaining() < 99 {
+		t.Errorf("header: key %q, value %q, flags %d, cas %d, ttl %d", item.Key(), item.Value(), item.Flags(), item.CAS(), item.TTLRemaining())
+	}
+
+	// the header replaces the request header, the key follows it directly
+	if ItemSize != 72 || !bytes.HasPrefix(sm.space(item), []byte("keyvalue")) {
+		t.Errorf("layout: header of %d bytes followed by %q", ItemSize, sm.space(item)[:8])
+	}
+
+	if refs := item.refs.Load(); refs != 1 {
+		t.Errorf("references of a stored object: expected 1 | get %d", refs)
+	}
+}
+
+func TestReferenceKeepsChunk(t *testing.T) {
+	sm := newTestManager()
+	writer := &bytes.Buffer{}
+
+	set, _ := parser.Set([]byte("key"), []byte("value"), 0)
+	request(t, sm, set, writer)
+	response(t, writer)
+
+	// a response reading the object holds a reference
+	item, _ := sm.shard("key").lookup("key")
+
+	// the object is modified by storing a new one, the old value stays as it was
+	appended, _ := parser.Encode(constants.AppendOperation, []byte("key"), []byte("-more"), 0)
+	request(t, sm, appended, writer)
+	response(t, writer)
+
+	if current, _ := stored(sm, "key"); current == item || string(current.Value()) != "value-more" || string(item.Value()) != "value" {
+		t.Errorf("append to a read object: stored %q, read %q", current.Value(), item.Value())
+	}
+
+	// the chunk isn't reused while the response holds it
+	if block, _, _ := sm.GetSlab(20); itemOf(block) == item {
+		t.Fatal("chunk of a read object was reused")
+	}
+
+	sm.unref(item)
+
+	if block, _, _ := sm.GetSlab(20); itemOf(block) != item {
+		t.Error("chunk was not freed with the last reference")
+	}
+}
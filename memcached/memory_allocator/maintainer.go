@@ -0,0 +1,62 @@
+package memory_allocator
+
+import (
+	"time"
+
+	"github.com/WatchJani/memCashed/memcached/constants"
+	"github.com/WatchJani/memCashed/memcached/link_list"
+)
+
+// LRUMaintainer moves objects between the hot, warm and cold segments of every slab's LRU,
+// so reads only mark the objects they find. It runs every LRUMaintainInterval.
+func (s *SlabManager) LRUMaintainer() {
+	ticker := time.NewTicker(constants.LRUMaintainInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.MaintainLRU(constants.LRUMaintainBatch)
+	}
+}
+
+// MaintainLRU moves at most budget objects between the segments of each slab's LRU in every shard,
+// policies without segments are skipped.
+func (s *SlabManager) MaintainLRU(budget int) {
+	for i := range s.shards {
+		shard := &s.shards[i]
+
+		for j := range shard.policy {
+			maintained, ok := shard.policy[j].(link_list.Maintained)
+			if !ok {
+				continue // The policy has no background work
+			}
+
+			warmed, cooled := maintained.Maintain(budget)
+
+			shard.stats.MovesToWarm.Add(uint64(warmed))
+			shard.stats.MovesToCold.Add(uint64(cooled))
+		}
+	}
+}
+
+// PageReclaimer gives the memory of pages without objects back to the OS in the background. A page is
+// only trimmed once it stayed empty for a whole PageReclaimInterval, so the chunks of requests freed right
+// after they are read don't cost a system call and a page fault each.
+func (s *SlabManager) PageReclaimer() {
+	ticker := time.NewTicker(constants.PageReclaimInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.ReclaimPages()
+	}
+}
+
+// ReclaimPages gives the memory of the pages that stayed empty since the previous call back to the OS.
+// It returns the number of pages trimmed.
+func (s *SlabManager) ReclaimPages() int {
+	trimmed := 0
+	for i := range s.slabs {
+		trimmed += s.slabs[i].trimPages()
+	}
+
+	return trimmed
+}
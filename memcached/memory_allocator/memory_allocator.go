@@ -2,27 +2,278 @@ package memory_allocator
 
 import (
 	"sync"
+	"sync/atomic"
+	"unsafe"
 
 	"github.com/WatchJani/memCashed/memcached/constants"
 )
 
-// Allocator is a memory allocator that manages a slice of bytes and keeps track of the next available index.
+// regionShift is the number of bits of a ref holding the offset in its region, the bits above it number the region.
+const regionShift = 40
+
+// Allocator is a memory allocator that hands out blocks of its regions up to its limit. The memory is
+// mapped lazily, a page only takes memory of the OS once it is written, and a block whose chunks stay
+// free gives its memory back. The limit can change while the cache runs, a higher one maps a new region.
 type Allocator struct {
-	memory []byte
-	next   int
-	sync.RWMutex
+	regions      atomic.Pointer[[]*region] // Regions of memory, never changed once published, a grow publishes a longer copy
+	limit        int                       // Number of bytes of blocks that may be handed out
+	taken        int                       // Number of bytes of blocks handed out
+	index        int                       // Number of bytes of the hash tables of the shards, counted against the limit
+	free         [][]byte                  // Blocks given back by a shrink, handed out before new ones
+	hugePages    bool                      // Set when the regions are backed by transparent huge pages
+	sync.RWMutex                           // Protects everything but the regions and the chunk counters
+}
+
+// region is a range of memory mapped at once, cut into blocks in order.
+type region struct {
+	memory []byte  // Memory of the region
+	next   int     // Offset of the first block never handed out, protected by the allocator lock
+	live   []int   // Chunks handed out in every block, protected by the lock of the slab the block belongs to
+	state  []uint8 // What the page reclaimer saw of every block, protected like the chunk counters
 }
 
-// GetNext returns the next available index in the allocator's memory.
+// States of a block seen by the page reclaimer.
+const (
+	blockBusy      uint8 = iota // A chunk was handed out since the last pass
+	blockEmpty                  // Every chunk was free at the last pass
+	blockDiscarded              // The memory went back to the OS, it reads as zero
+)
+
+// GetNext returns the number of bytes of blocks handed out.
 func (a *Allocator) GetNext() int {
-	return a.next
+	a.RLock()
+	defer a.RUnlock()
+
+	return a.taken
+}
+
+// IndexBytes returns the number of bytes of the hash tables of the shards.
+func (a *Allocator) IndexBytes() int {
+	a.RLock()
+	defer a.RUnlock()
+
+	return a.index
+}
+
+// growIndex adds delta bytes to the memory of the hash tables. Their slots are mapped outside the regions,
+// the memory still counts against the limit, so fewer blocks are handed out.
+func (a *Allocator) growIndex(delta int) {
+	a.Lock()
+	defer a.Unlock()
+
+	a.index += delta
+}
+
+// Capacity returns the number of bytes the allocator may hand out.
+func (a *Allocator) Capacity() int {
+	a.RLock()
+	defer a.RUnlock()
+
+	return a.limit
 }
 
 // New creates a new Allocator with the specified capacity.
-func New(capacity int) *Allocator {
-	return &Allocator{
-		memory: make([]byte, capacity),
+// It returns an error if the memory can't be mapped.
+func New(capacity int) (*Allocator, error) {
+	a := &Allocator{limit: capacity}
+	if err := a.addRegion(capacity); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// newRegionOf maps a region of size bytes, rounded down to whole blocks.
+func newRegionOf(size int) (*region, error) {
+	blocks := size / constants.MiB
+
+	memory, err := newRegion(blocks * constants.MiB)
+	if err != nil {
+		return nil, err
 	}
+
+	return &region{memory: memory, live: make([]int, blocks), state: make([]uint8, blocks)}, nil
+}
+
+// addRegion maps size bytes and publishes them with the other regions. The memory is split into regions
+// of at most 1<<regionShift bytes, so a ref can hold the offset of every chunk.
+// The caller must hold the allocator lock, or be its only user.
+func (a *Allocator) addRegion(size int) error {
+	for size > 0 {
+		r, err := newRegionOf(min(size, 1<<regionShift))
+		if err != nil {
+			return err
+		}
+
+		if a.hugePages && len(r.memory) > 0 {
+			hugePages(r.memory) // The first region accepted them, so does this one
+		}
+
+		regions := append(append([]*region(nil), a.list()...), r)
+		a.regions.Store(&regions)
+		size -= 1 << regionShift
+	}
+
+	return nil
+}
+
+// list returns the regions of the allocator.
+func (a *Allocator) list() []*region {
+	if regions := a.regions.Load(); regions != nil {
+		return *regions
+	}
+
+	return nil
+}
+
+// EnableHugePages backs the memory of the allocator with transparent huge pages, which take fewer
+// TLB entries for a large cache. A block freed while huge pages back it splits its huge page.
+func (a *Allocator) EnableHugePages() error {
+	a.Lock()
+	defer a.Unlock()
+
+	for _, r := range a.list() {
+		if len(r.memory) == 0 {
+			continue
+		}
+
+		if err := hugePages(r.memory); err != nil {
+			return err
+		}
+	}
+
+	a.hugePages = true
+	return nil
+}
+
+// SetLimit changes the number of bytes the allocator may hand out, rounded down to whole blocks.
+// A limit above the memory of the regions maps a new region for the difference. Blocks handed out
+// over a lower limit stay where they are until they are given back by Release.
+// It returns an error and keeps the old limit if the new region can't be mapped.
+func (a *Allocator) SetLimit(limit int) error {
+	a.Lock()
+	defer a.Unlock()
+
+	limit = max(limit, 0) / constants.MiB * constants.MiB
+
+	mapped := 0
+	for _, r := range a.list() {
+		mapped += len(r.memory)
+	}
+
+	if limit > mapped {
+		if err := a.addRegion(limit - mapped); err != nil {
+			return err
+		}
+	}
+
+	a.limit = limit
+	return nil
+}
+
+// restoreLimit puts back a limit the allocator had before, its memory is mapped already.
+func (a *Allocator) restoreLimit(limit int) {
+	a.Lock()
+	defer a.Unlock()
+
+	a.limit = limit
+}
+
+// Excess returns the number of bytes of blocks and hash tables over the limit.
+func (a *Allocator) Excess() int {
+	a.RLock()
+	defer a.RUnlock()
+
+	return max(a.taken+a.index-a.limit, 0)
+}
+
+// Release takes back a block whose chunks are all free, its memory goes back to the OS.
+func (a *Allocator) Release(block []byte) {
+	a.Lock()
+	defer a.Unlock()
+
+	a.empty(block)
+	a.free = append(a.free, block)
+	a.taken -= constants.MiB
+}
+
+// locate returns the region holding the memory, with its number, and the offset of the memory in it.
+func (a *Allocator) locate(pointer unsafe.Pointer) (*region, int, uintptr) {
+	for i, r := range a.list() {
+		if offset := uintptr(pointer) - uintptr(unsafe.Pointer(unsafe.SliceData(r.memory))); offset < uintptr(len(r.memory)) {
+			return r, i, offset
+		}
+	}
+
+	panic("memory_allocator: pointer outside of the arena")
+}
+
+// claim counts a chunk of the block holding it as handed out.
+// The caller must hold the lock of the slab the block belongs to.
+func (a *Allocator) claim(pointer unsafe.Pointer) {
+	r, _, offset := a.locate(pointer)
+	r.live[offset/constants.MiB]++
+	r.state[offset/constants.MiB] = blockBusy
+}
+
+// reclaim counts a chunk of the block holding it as free.
+// The caller must hold the lock of the slab the block belongs to.
+func (a *Allocator) reclaim(pointer unsafe.Pointer) {
+	r, _, offset := a.locate(pointer)
+	r.live[offset/constants.MiB]--
+}
+
+// chunks returns the number of chunks of the block handed out.
+// The caller must hold the lock of the slab the block belongs to.
+func (a *Allocator) chunks(block []byte) int {
+	r, _, offset := a.locate(unsafe.Pointer(&block[0]))
+	return r.live[offset/constants.MiB]
+}
+
+// trim gives the memory of the block back to the OS if none of its chunks was handed out since the
+// previous pass found it empty, its chunks stay on the free list of the slab. It reports whether it did.
+// A block that only holds requests for a moment keeps its memory.
+// The caller must hold the lock of the slab the block belongs to.
+func (a *Allocator) trim(block []byte) bool {
+	r, _, offset := a.locate(unsafe.Pointer(&block[0]))
+
+	i := offset / constants.MiB
+	if r.live[i] > 0 {
+		return false
+	}
+
+	switch r.state[i] {
+	case blockBusy:
+		r.state[i] = blockEmpty
+	case blockEmpty:
+		discard(block)
+		r.state[i] = blockDiscarded
+		return true
+	}
+
+	return false
+}
+
+// empty gives the memory of a block with every chunk free back to the OS, when it moves to another slab.
+// The caller must hold the locks of the slabs the block moves between.
+func (a *Allocator) empty(block []byte) {
+	r, _, offset := a.locate(unsafe.Pointer(&block[0]))
+	r.live[offset/constants.MiB] = 0
+	r.state[offset/constants.MiB] = blockDiscarded
+	discard(block)
+}
+
+// pointer returns the memory the ref refers to.
+func (a *Allocator) pointer(ref uint64) unsafe.Pointer {
+	r := a.list()[ref>>regionShift]
+	return unsafe.Add(unsafe.Pointer(unsafe.SliceData(r.memory)), ref&(1<<regionShift-1))
+}
+
+// ref returns the number of the region holding the memory and the offset of the memory in it,
+// packed in a single number.
+func (a *Allocator) ref(pointer unsafe.Pointer) uint64 {
+	_, i, offset := a.locate(pointer)
+	return uint64(i)<<regionShift | uint64(offset)
 }
 
 // IsEnoughSpace checks if there is enough space to allocate a block of memory from 'end' position to the given length.
@@ -30,19 +281,34 @@ func IsEnoughSpace(end, len int) bool {
 	return end <= len
 }
 
-// AllocateBlock allocates a block of memory in the allocator.
-// It locks the allocator for thread-safety and returns a slice of bytes or an error if there isn't enough space.
+// AllocateBlock allocates a block of memory in the allocator, a block given back by a shrink first.
+// It locks the allocator for thread-safety and returns a slice of bytes or an error if the limit
+// doesn't allow another block.
 func (a *Allocator) AllocateBlock() ([]byte, error) {
 	a.Lock()
 	defer a.Unlock()
 
-	start := a.next
-	end := start + constants.MiB
-
-	if !IsEnoughSpace(end, len(a.memory)) {
+	if !IsEnoughSpace(a.taken+a.index+constants.MiB, a.limit) {
 		return nil, constants.ErrNotEnoughSpace
 	}
 
-	a.next = end
-	return a.memory[start:end], nil
+	if n := len(a.free); n > 0 {
+		block := a.free[n-1]
+		a.free = a.free[:n-1]
+		a.taken += constants.MiB
+		return block, nil
+	}
+
+	for _, r := range a.list() {
+		start := r.next
+		end := start + constants.MiB
+
+		if IsEnoughSpace(end, len(r.memory)) {
+			r.next = end
+			a.taken += constants.MiB
+			return r.memory[start:end], nil
+		}
+	}
+
+	return nil, constants.ErrNotEnoughSpace
 }
@@ -1,14 +1,77 @@
 package memory_allocator
 
 import (
+	"bytes"
+	"runtime"
 	"testing"
 	"unsafe"
+
+	"github.com/WatchJani/memCashed/memcached/constants"
 )
 
+// indexRoom is the memory a test arena has for the hash tables on top of its blocks, they count against
+// the limit. It is less than a block, so the arena maps no more blocks than the test asks for.
+const indexRoom = constants.MiB / 2
+
+// newArena creates an allocator for a test whose blocks add up to capacity, with room for the hash tables
+// on top. It panics if the memory can't be mapped.
+func newArena(capacity int) *Allocator {
+	allocator, err := New(capacity + indexRoom)
+	if err != nil {
+		panic(err)
+	}
+
+	return allocator
+}
+
+func TestAllocatorReturnsEmptyBlocks(t *testing.T) {
+	allocator := newArena(4 * constants.MiB)
+	slab := NewSlab(constants.MiB/4, 0, allocator)
+
+	chunks := make([][]byte, 4)
+	for i := range chunks {
+		chunks[i], _ = slab.AllocateMemory()
+		copy(chunks[i], "data")
+	}
+
+	// a block with a chunk handed out keeps its memory
+	for _, chunk := range chunks[1:] {
+		slab.Free(unsafe.Pointer(&chunk[0]))
+	}
+
+	if allocator.list()[0].live[0] != 1 || !bytes.HasPrefix(chunks[0], []byte("data")) {
+		t.Fatalf("block with a chunk in use: %d chunks, data %q", allocator.list()[0].live[0], chunks[0][:4])
+	}
+
+	slab.Free(unsafe.Pointer(&chunks[0][0]))
+
+	// freeing the last chunk costs no system call, the block keeps its memory until it stays empty
+	if slab.trimPages() != 0 || !bytes.HasPrefix(chunks[0], []byte("data")) {
+		t.Fatalf("block trimmed as soon as it was empty, data %q", chunks[0][:4])
+	}
+
+	// a chunk handed out and freed in between keeps the block until the next pass
+	chunk, _ := slab.AllocateMemory()
+	slab.Free(unsafe.Pointer(&chunk[0]))
+
+	if slab.trimPages() != 0 || slab.trimPages() != 1 {
+		t.Fatal("block empty for a whole pass: expected to be trimmed")
+	}
+
+	// the pages of the empty block are given back, they read as zero
+	if runtime.GOOS == "linux" && !bytes.Equal(chunks[0][:4], make([]byte, 4)) {
+		t.Errorf("empty block still holds %q", chunks[0][:4])
+	}
+
+	if chunk, _ := slab.AllocateMemory(); allocator.list()[0].live[0] != 1 || len(chunk) != constants.MiB/4 {
+		t.Errorf("chunk of an empty block: %d chunks in use", allocator.list()[0].live[0])
+	}
+}
+
 func BenchmarkAllocator(b *testing.B) {
 	b.StopTimer()
 
-	allocator := New(5 * 1024 * 1024)
+	allocator := newArena(5 * 1024 * 1024)
 
 	b.StartTimer()
 
@@ -0,0 +1,261 @@
+package memory_allocator
+
+import (
+	"cmp"
+	"slices"
+	"time"
+	"unsafe"
+
+	"github.com/WatchJani/memCashed/memcached/constants"
+)
+
+// automove holds what the page rebalancer saw at its last check.
+type automove struct {
+	pressure []uint64 // Evictions and refused requests of every slab at the last check
+	windows  []int    // Number of consecutive checks in which the slab was under pressure
+}
+
+// Automove moves pages from idle slabs to the slab that evicts the most, so the memory follows
+// the workload when it shifts between small and large objects. It checks every AutomoveInterval.
+func (s *SlabManager) Automove() {
+	ticker := time.NewTicker(constants.AutomoveInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.Rebalance()
+	}
+}
+
+// Rebalance compares the eviction pressure of the slabs since the last call. A slab that evicted in the
+// last AutomoveWindows checks, the most in this one, gets a page from the idle slab with the most pages.
+// Idle slabs keep at least one page. It returns true if a page was moved.
+func (s *SlabManager) Rebalance() bool {
+	state := &s.automove
+	if state.pressure == nil {
+		state.pressure = make([]uint64, len(s.slabs))
+		state.windows = make([]int, len(s.slabs))
+	}
+
+	receiver, most := -1, uint64(0)
+	idle := make([]bool, len(s.slabs))
+
+	for i := range s.slabs {
+		stat := s.slabs[i].Stat()
+		pressure := stat.Evictions + stat.OutOfMemory
+		delta := pressure - state.pressure[i]
+		state.pressure[i] = pressure
+
+		if delta == 0 {
+			state.windows[i] = 0
+			idle[i] = true
+			continue
+		}
+
+		state.windows[i]++
+		full := stat.MaxPages > 0 && stat.Pages >= stat.MaxPages // A page would go over the limit of the slab
+		if state.windows[i] >= constants.AutomoveWindows && delta > most && !full {
+			receiver, most = i, delta
+		}
+	}
+
+	if receiver == -1 {
+		return false
+	}
+
+	donor, pages := -1, 1
+	for i := range s.slabs {
+		if stat := s.slabs[i].Stat(); idle[i] && stat.Pages > pages {
+			donor, pages = i, stat.Pages
+		}
+	}
+
+	if donor == -1 || !s.movePage(donor, receiver) {
+		return false
+	}
+
+	state.windows[receiver] = 0
+	s.stats.SlabsMoved.Add(1)
+	return true
+}
+
+// movePage empties a page of the donor slab and gives it to the receiver.
+// It returns false if every page of the donor holds a request that isn't stored yet.
+func (s *SlabManager) movePage(donor, receiver int) bool {
+	page := s.takePage(donor)
+	if page == nil {
+		return false
+	}
+
+	to := &s.slabs[receiver]
+	to.Lock()
+	to.addPage(page)
+	to.Unlock()
+
+	return true
+}
+
+// takePage empties a page of the slab and takes it away from it, the pages with the fewest chunks
+// in use are tried first. It returns nil if every page of the slab holds a request that isn't stored yet.
+func (s *SlabManager) takePage(index int) []byte {
+	for _, page := range s.slabs[index].candidates() {
+		if s.drainPage(index, page) {
+			return page
+		}
+	}
+
+	return nil
+}
+
+// candidates returns the pages of the slab, the ones with the fewest chunks in use first.
+func (s *Slab) candidates() [][]byte {
+	s.Lock()
+	defer s.Unlock()
+
+	pages := slices.Clone(s.pages)
+	slices.SortStableFunc(pages, func(a, b []byte) int {
+		return cmp.Compare(s.chunks(a), s.chunks(b))
+	})
+
+	return pages
+}
+
+// drainPage takes the page away from the slab. The objects in it are copied to free chunks of the slab
+// outside the page, and evicted once there are none left. A page is only taken if every chunk in it is
+// free or holds a stored object no response reads, chunks of requests still waiting for a worker and
+// of objects still being written to a connection must stay where they are. Only the chunks of the page
+// are read, and only the shards of the objects in them are locked. It reports whether it took the page.
+func (s *SlabManager) drainPage(index int, page []byte) bool {
+	slab := &s.slabs[index]
+
+	// The headers are read without the locks to find the shards to lock, they are checked again under them
+	shards := s.shardsIn(page, slab.slabSize)
+	s.lockShards(shards)
+	defer s.unlockShards(shards)
+
+	slab.Lock()
+	defer slab.Unlock()
+
+	position := slices.IndexFunc(slab.pages, func(p []byte) bool { return &p[0] == &page[0] })
+	if position == -1 {
+		return false // The page was taken in the meantime
+	}
+
+	var items []*Item
+	for offset := 0; offset+slab.slabSize <= len(page); offset += slab.slabSize {
+		item := (*Item)(unsafe.Pointer(&page[offset]))
+
+		// Chunks never handed out, or whose memory went back to the OS, read as zero
+		hash := item.Hash()
+		if hash == 0 {
+			continue
+		}
+
+		shard := &s.shards[s.shardOfHash(hash)]
+		if !slices.Contains(shards, s.shardOfHash(hash)) {
+			return false // The chunk was handed out again since the shards were chosen
+		}
+
+		// A free chunk, or a request, still has the header of the object it held before
+		if !shard.index.has(hash, s.arena.ref(unsafe.Pointer(item))) {
+			continue
+		}
+
+		if item.isShared() {
+			return false
+		}
+
+		items = append(items, item)
+	}
+
+	// Every chunk in use holds a stored object
+	if len(items) != slab.chunks(page) {
+		return false
+	}
+
+	inPage := func(pointer unsafe.Pointer) bool {
+		return uintptr(pointer)-uintptr(unsafe.Pointer(&page[0])) < uintptr(len(page))
+	}
+
+	slab.freeList.Filter(func(pointer unsafe.Pointer) bool {
+		return !inPage(pointer)
+	})
+
+	isCurrent := slab.currentPage != nil && &slab.currentPage[0] == &page[0]
+
+	// Chunks of the current page never handed out can take the objects too
+	if !isCurrent && slab.currentPage != nil {
+		for ; slab.pagePointer+slab.slabSize <= len(slab.currentPage); slab.pagePointer += slab.slabSize {
+			slab.freeList.Push(unsafe.Pointer(&slab.currentPage[slab.pagePointer]))
+		}
+	}
+
+	// The objects are moved in the order of the page, the rest are evicted once the free chunks run out
+	for _, item := range items {
+		s.rescue(index, item)
+	}
+
+	if isCurrent {
+		slab.currentPage, slab.pagePointer = nil, 0
+	}
+
+	slab.pages = append(slab.pages[:position], slab.pages[position+1:]...)
+	return true
+}
+
+// shardsIn returns the shards the headers of the chunks of the page point to, in order of their index.
+func (s *SlabManager) shardsIn(page []byte, chunkSize int) []int {
+	seen := make([]bool, len(s.shards))
+	for offset := 0; offset+chunkSize <= len(page); offset += chunkSize {
+		if hash := (*Item)(unsafe.Pointer(&page[offset])).Hash(); hash != 0 {
+			seen[s.shardOfHash(hash)] = true
+		}
+	}
+
+	var shards []int
+	for i, isSeen := range seen {
+		if isSeen {
+			shards = append(shards, i)
+		}
+	}
+
+	return shards
+}
+
+// rescue moves the stored object to a free chunk of its slab, or evicts it if there is none.
+// The header, the key and the value move together, the node keeps its place in the policy.
+// The caller must hold the locks of the shard of the object and of the slab.
+func (s *SlabManager) rescue(index int, item *Item) {
+	slab := &s.slabs[index]
+	shard := &s.shards[s.shardOfHash(item.Hash())]
+
+	if slab.freeList.IsEmpty() {
+		shard.unlink(item)
+		slab.used--
+
+		shard.stats.SlabReassigned.Add(1)
+		return
+	}
+
+	pointer, _ := slab.freeList.Pop()
+	slab.claim(pointer)
+	to := (*Item)(pointer)
+	copy(to.chunk(slab.slabSize), item.chunk(slab.slabSize))
+
+	shard.policy[index].Relocate(&item.Node, &to.Node)
+	shard.index.replace(item, to)
+	shard.schedule(to) // The entry of the old chunk no longer finds the object
+
+	shard.stats.SlabRescues.Add(1)
+}
+
+// addPage cuts the page into chunks of the slab and adds them to its free list. The objects moved out,
+// so the memory of the page goes back to the OS until the slab uses it. The caller must hold the slab lock.
+func (s *Slab) addPage(page []byte) {
+	s.empty(page)
+
+	for offset := 0; offset+s.slabSize <= len(page); offset += s.slabSize {
+		s.freeList.Push(unsafe.Pointer(&page[offset]))
+	}
+
+	s.pages = append(s.pages, page)
+}
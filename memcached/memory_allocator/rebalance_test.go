@@ -0,0 +1,76 @@
+package memory_allocator
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/WatchJani/memCashed/memcached/constants"
+	"github.com/WatchJani/memCashed/memcached/parser"
+)
+
+func TestRebalance(t *testing.T) {
+	allocator := newArena(2 * constants.MiB)
+	sm := NewSlabManager([]Slab{NewSlab(128, 0, allocator), NewSlab(1024, 0, allocator)}, 1)
+	writer := &bytes.Buffer{}
+
+	// small objects take both pages of the arena, the second one only partly
+	perPage := constants.MiB / 128
+	for i := range perPage + 100 {
+		set, _ := parser.Set(fmt.Appendf(nil, "key-%d", i), fmt.Appendf(nil, "%d", i), 0)
+		request(t, sm, set, writer)
+		response(t, writer)
+	}
+
+	// the oldest objects are deleted, their chunks in the first page are free
+	for i := range 200 {
+		del, _ := parser.Delete(fmt.Appendf(nil, "key-%d", i))
+		request(t, sm, del, writer)
+		response(t, writer)
+	}
+
+	// the large class has no page and nothing to evict, until it was refused for a few checks
+	for window := range constants.AutomoveWindows {
+		if _, _, err := sm.GetSlab(900); err == nil {
+			t.Fatal("large class: expected no memory before the rebalance")
+		}
+
+		if moved := sm.Rebalance(); moved != (window == constants.AutomoveWindows-1) {
+			t.Fatalf("window %d: page moved %v", window, moved)
+		}
+	}
+
+	if _, _, err := sm.GetSlab(900); err != nil {
+		t.Fatalf("large class after the rebalance: %v", err)
+	}
+
+	stats := sm.SlabStats()
+	if stats[0].Pages != 1 || stats[1].Pages != 1 || stats[0].UsedChunks != perPage-100 {
+		t.Errorf("pages: expected one page for each class, the small one with %d objects | get %+v", perPage-100, stats)
+	}
+
+	// the page with the fewest objects is moved, they fill the free chunks of the other page
+	if rescues, evicted := sm.Stats().SlabRescues.Load(), sm.Stats().SlabReassigned.Load(); rescues != 100 || evicted != 0 {
+		t.Errorf("objects: expected 100 rescued and none evicted | get %d and %d", rescues, evicted)
+	}
+
+	if items := sm.Stats().CurrItems.Load(); items != int64(perPage-100) {
+		t.Errorf("curr_items: expected %d | get %d", perPage-100, items)
+	}
+
+	// the deleted objects stay deleted, the moved ones keep their values
+	if _, status := sm.shard("key-199").lookup("key-199"); status != constants.StatusNotFound {
+		t.Errorf("key-199: expected %d | get %d", constants.StatusNotFound, status)
+	}
+
+	key := fmt.Sprint("key-", perPage+99)
+	if item, status := sm.shard(key).lookup(key); status != constants.StatusOK || string(item.Value()) != fmt.Sprint(perPage+99) {
+		t.Errorf("%s: expected %d %q | get %d", key, constants.StatusOK, fmt.Sprint(perPage+99), status)
+	} else {
+		sm.unref(item)
+	}
+
+	if sm.Rebalance() {
+		t.Error("a class with a single page gave it away")
+	}
+}
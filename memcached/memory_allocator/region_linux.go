@@ -0,0 +1,39 @@
+package memory_allocator
+
+import (
+	"log"
+	"syscall"
+)
+
+// newRegion returns size bytes of anonymous memory mapped outside the Go heap, so the GC never scans it.
+// The kernel commits the pages on first use, memory that is never written costs nothing.
+func newRegion(size int) ([]byte, error) {
+	if size == 0 {
+		return nil, nil
+	}
+
+	return syscall.Mmap(-1, 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE|syscall.MAP_ANONYMOUS|syscall.MAP_NORESERVE)
+}
+
+// freeRegion unmaps a region returned by newRegion.
+func freeRegion(region []byte) {
+	if len(region) == 0 {
+		return
+	}
+
+	if err := syscall.Munmap(region); err != nil && err != syscall.EINVAL { // EINVAL for memory of the Go heap
+		log.Println(err)
+	}
+}
+
+// discard returns the pages of the memory to the OS. They read as zero when they are used again.
+func discard(memory []byte) {
+	if err := syscall.Madvise(memory, syscall.MADV_DONTNEED); err != nil && err != syscall.EINVAL {
+		log.Println(err)
+	}
+}
+
+// hugePages asks the kernel to back the memory with transparent huge pages.
+func hugePages(memory []byte) error {
+	return syscall.Madvise(memory, syscall.MADV_HUGEPAGE)
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+
+package memory_allocator
+
+import "github.com/WatchJani/memCashed/memcached/constants"
+
+// newRegion returns size bytes of memory holding no pointers, so the GC never scans it.
+func newRegion(size int) ([]byte, error) {
+	return make([]byte, size), nil
+}
+
+// freeRegion leaves a region returned by newRegion to the GC.
+func freeRegion(region []byte) {}
+
+// discard does nothing, the pages stay with the process.
+func discard(memory []byte) {}
+
+// hugePages reports that transparent huge pages are only supported on Linux.
+func hugePages(memory []byte) error {
+	return constants.ErrHugePages
+}
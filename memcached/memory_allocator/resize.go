@@ -0,0 +1,59 @@
+package memory_allocator
+
+import (
+	"cmp"
+	"slices"
+
+	"github.com/WatchJani/memCashed/memcached/constants"
+)
+
+// Resize changes the number of bytes the slabs can allocate while the cache runs. A higher limit maps
+// a new region of memory for the slabs to grow into. A lower one takes pages away from the slab classes
+// under the least pressure first, copying their objects to free chunks of their class or evicting them,
+// until the slabs hold no more than the limit. It returns ErrShrink if every page left holds a request
+// still waiting for a worker or an object a response still reads, the old limit is restored and the pages
+// given back on the way stay free. A higher limit whose memory can't be mapped returns the error of
+// the mapping and keeps the old limit.
+func (s *SlabManager) Resize(limit int) error {
+	previous := s.arena.Capacity()
+	if err := s.arena.SetLimit(limit); err != nil {
+		return err
+	}
+
+	for s.arena.Excess() > 0 {
+		if !s.releasePage() {
+			s.arena.restoreLimit(previous)
+			return constants.ErrShrink
+		}
+	}
+
+	return nil
+}
+
+// releasePage gives a page of the slab class under the least pressure back to the allocator, the classes
+// that evict or refuse requests the least go first. It returns false if no class has a page it can give.
+func (s *SlabManager) releasePage() bool {
+	classes := make([]int, 0, len(s.slabs))
+	pressure := make([]uint64, len(s.slabs))
+
+	for i := range s.slabs {
+		if stat := s.slabs[i].Stat(); stat.Pages > 0 {
+			classes = append(classes, i)
+			pressure[i] = stat.Evictions + stat.OutOfMemory
+		}
+	}
+
+	slices.SortStableFunc(classes, func(a, b int) int {
+		return cmp.Compare(pressure[a], pressure[b])
+	})
+
+	for _, class := range classes {
+		if page := s.takePage(class); page != nil {
+			s.arena.Release(page)
+			s.stats.PagesReleased.Add(1)
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,102 @@
+package memory_allocator
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/WatchJani/memCashed/memcached/constants"
+	"github.com/WatchJani/memCashed/memcached/parser"
+)
+
+func TestResize(t *testing.T) {
+	allocator := newArena(2 * constants.MiB)
+	sm := NewSlabManager([]Slab{NewSlab(128, 0, allocator), NewSlab(1024, 0, allocator)}, 1)
+	writer := &bytes.Buffer{}
+
+	store := func(key string, size int) {
+		set, _ := parser.Set([]byte(key), bytes.Repeat([]byte("v"), size), 0)
+		request(t, sm, set, writer)
+		response(t, writer)
+	}
+
+	// each class takes a page of the arena, the small class evicts once it is full
+	store("large-0", 800)
+
+	perPage := constants.MiB / 128
+	for i := range perPage + 1 {
+		store(fmt.Sprint("small-", i), 1)
+	}
+
+	// a higher limit maps a new region, the large class grows into it without evicting
+	if err := sm.Resize(4 * constants.MiB); err != nil || sm.LimitMaxBytes() != 4*constants.MiB {
+		t.Fatalf("grow: %v, limit %d", err, sm.LimitMaxBytes())
+	}
+
+	for i := 1; i <= constants.MiB/1024+10; i++ {
+		store(fmt.Sprint("large-", i), 800)
+	}
+
+	if stats := sm.SlabStats(); stats[1].Pages != 2 || stats[1].Evictions != 0 || len(allocator.list()) != 2 {
+		t.Fatalf("large class: expected 2 pages without evictions in 2 regions | get %+v in %d regions", stats[1], len(allocator.list()))
+	}
+
+	if _, isFound := stored(sm, fmt.Sprint("large-", constants.MiB/1024+10)); !isFound {
+		t.Error("object in the new region: expected to be found")
+	}
+
+	// a lower limit takes the pages of the class that evicts the least, the hash table takes a block of it
+	if err := sm.Resize(2 * constants.MiB); err != nil || sm.LimitMaxBytes() != 2*constants.MiB || allocator.Excess() != 0 {
+		t.Fatalf("shrink: %v, limit %d, excess %d", err, sm.LimitMaxBytes(), allocator.Excess())
+	}
+
+	if stats := sm.SlabStats(); stats[0].Pages != 1 || stats[1].Pages != 0 || sm.Stats().PagesReleased.Load() != 2 {
+		t.Errorf("pages: expected only the small class to keep its page | get %+v", stats)
+	}
+
+	if _, isFound := stored(sm, fmt.Sprint("small-", perPage)); !isFound {
+		t.Error("small class: expected to keep its objects")
+	}
+
+	// the limit holds for new pages, a page given back is handed out again once it is raised
+	if _, _, err := sm.GetSlab(900); err == nil {
+		t.Error("large class: expected no memory under the lower limit")
+	}
+
+	if err := sm.Resize(3 * constants.MiB); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := sm.GetSlab(900); err != nil || len(allocator.list()) != 2 {
+		t.Errorf("large class after raising the limit: %v in %d regions", err, len(allocator.list()))
+	}
+}
+
+func TestResizeFailedShrink(t *testing.T) {
+	allocator := newArena(2 * constants.MiB)
+	sm := NewSlabManager([]Slab{NewSlab(128, 0, allocator)}, 1)
+	writer := &bytes.Buffer{}
+
+	set, _ := parser.Set([]byte("key"), []byte("value"), 0)
+	request(t, sm, set, writer)
+	response(t, writer)
+
+	// a response still reads the only object, its page can't be given back
+	item, _ := sm.shard("key").lookup("key")
+	previous := sm.LimitMaxBytes()
+
+	if err := sm.Resize(constants.MiB); err != constants.ErrShrink {
+		t.Fatalf("shrink: expected %v | get %v", constants.ErrShrink, err)
+	}
+
+	// the old limit stays, new pages are still handed out under it
+	if limit := sm.LimitMaxBytes(); limit != previous || allocator.Excess() != 0 {
+		t.Errorf("limit after the failed shrink: expected %d | get %d, excess %d", previous, limit, allocator.Excess())
+	}
+
+	// once the response is done the page goes back
+	sm.unref(item)
+	if err := sm.Resize(constants.MiB); err != nil || sm.SlabStats()[0].Pages != 0 {
+		t.Errorf("shrink after the response: %v, %d pages", err, sm.SlabStats()[0].Pages)
+	}
+}
@@ -0,0 +1,95 @@
+package memory_allocator
+
+import (
+	"hash/maphash"
+	"sync"
+
+	"github.com/WatchJani/memCashed/memcached/constants"
+	"github.com/WatchJani/memCashed/memcached/link_list"
+	decoder "github.com/WatchJani/memCashed/memcached/parser"
+)
+
+// Shard is a partition of the keys, selected by key hash. It owns the hash table, the eviction policies
+// and the counters of its keys behind a lock of its own, so requests for keys of different shards
+// don't wait on each other. The slabs are shared by all shards.
+type Shard struct {
+	policy       []link_list.Policy // Eviction policy of every slab class for the keys of the shard
+	sync.RWMutex                    // Protects the index, the expiries and the headers of the objects
+	index        index              // Objects of the shard by key
+	stats        Stats              // Counters of the shard, added up by the stats command
+	expiries     expiryHeap         // Objects of the shard with a TTL by expiration time, protected by the mutex
+	jobs         chan Transfer      // Requests for keys of the shard, handled by the workers of the shard
+	*SlabManager                    // Slab manager the shard belongs to
+}
+
+// hash returns the hash of the key, never 0 so the index can mark empty slots with it.
+func (s *SlabManager) hash(key []byte) uint64 {
+	return maphash.Bytes(s.seed, key) | 1
+}
+
+// hashString returns the hash of the key, the same as hash returns for its bytes.
+func (s *SlabManager) hashString(key string) uint64 {
+	return maphash.String(s.seed, key) | 1
+}
+
+// shardIndex returns the index of the shard holding the key.
+func (s *SlabManager) shardIndex(key []byte) int {
+	return s.shardOfHash(s.hash(key))
+}
+
+// shardOfHash returns the index of the shard holding the key with the given hash. It takes the high
+// bits of the hash, the index of the shard probes by the low ones.
+func (s *SlabManager) shardOfHash(hash uint64) int {
+	return partition(hash, len(s.shards))
+}
+
+// partition spreads key hashes evenly over n parts by the high bits of the hash.
+func partition(hash uint64, n int) int {
+	return int((hash >> 32) * uint64(n) >> 32)
+}
+
+// shard returns the shard holding the key.
+func (s *SlabManager) shard(key string) *Shard {
+	return &s.shards[s.shardOfHash(s.hashString(key))]
+}
+
+// shardOf returns the shard of the key in the request header. Requests without a key, like the ones
+// for many keys, go to every shard in turn, they take every key to its own shard while they run.
+func (s *SlabManager) shardOf(payload []byte) *Shard {
+	if len(payload) < constants.HeaderSize {
+		return &s.shards[0]
+	}
+
+	_, keySize, _, _ := decoder.Decode(payload)
+	if keySize == 0 {
+		return &s.shards[s.spread.Add(1)%uint64(len(s.shards))]
+	}
+
+	end := min(constants.HeaderSize+int(keySize), len(payload))
+
+	return &s.shards[s.shardIndex(payload[constants.HeaderSize:end])]
+}
+
+// Dispatch sends the request to the workers of the shard holding its key.
+func (s *SlabManager) Dispatch(payload Transfer) {
+	s.shardOf(payload.payload).jobs <- payload
+}
+
+// Shards returns the number of shards.
+func (s *SlabManager) Shards() int {
+	return len(s.shards)
+}
+
+// lockShards locks the shards with the given indexes, which must be in ascending order.
+func (s *SlabManager) lockShards(indexes []int) {
+	for _, i := range indexes {
+		s.shards[i].Lock()
+	}
+}
+
+// unlockShards unlocks the shards locked by lockShards.
+func (s *SlabManager) unlockShards(indexes []int) {
+	for _, i := range indexes {
+		s.shards[i].Unlock()
+	}
+}
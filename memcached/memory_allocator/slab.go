@@ -1,10 +1,10 @@
 package memory_allocator
 
 import (
-	"fmt"
+	"hash/maphash"
 	"io"
-	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unsafe"
 
@@ -13,13 +13,20 @@ import (
 	"github.com/WatchJani/memCashed/memcached/stack"
 )
 
-// SlabManager manages slabs, LRU (Least Recently Used) caches, and memory allocation.
+// SlabManager manages slabs, eviction policies, and memory allocation.
+// The keys are split into shards, the slabs are shared by all of them.
 type SlabManager struct {
-	slabs        []Slab          // Slabs for memory allocation
-	lru          []link_list.DLL // Least Recently Used (LRU) cache for each slab
-	sync.RWMutex                 // Mutex to protect concurrent access to shared data
-	store        sync.Map        // Store to hold key-value pairs for cache management
-	JobCh        chan Transfer   // Channel to receive transfer jobs for processing
+	slabs     []Slab        // Slabs for memory allocation
+	shards    []Shard       // Partitions of the keys, each with its own hash table, eviction policies and lock
+	seed      maphash.Seed  // Seed of the hash selecting the shard of a key
+	next      atomic.Uint64 // Shard that evicts first for the next request without a key
+	spread    atomic.Uint64 // Shard whose workers run the next request without a key
+	workers   int           // Number of worker goroutines
+	cas       atomic.Uint64 // Last CAS value handed out to a stored object
+	stats     Stats         // Counters of the slabs, the shards count the rest
+	automove  automove      // Pressure seen by the page rebalancer at its last check
+	admission *Admission    // Filter deciding if a new object may evict, nil when disabled
+	arena     *Allocator    // Memory shared by all slabs, the indexes refer to the objects by their offset in it
 }
 
 // Transfer represents a data payload and connection information for a transfer task.
@@ -29,13 +36,6 @@ type Transfer struct {
 	index   int       // Index of the slab category
 }
 
-// Key represents a stored object with its field, TTL (Time-To-Live), and a pointer to its node in the LRU list.
-type Key struct {
-	field   []byte          // Object data field
-	ttl     time.Time       // Time-To-Live for the object
-	pointer *link_list.Node // Pointer to the node in the LRU list
-}
-
 // NewTransfer creates a new Transfer object with the specified payload, index, and connection.
 func NewTransfer(payload []byte, index int, conn io.Writer) Transfer { //Connection -> io.writer
 	return Transfer{
@@ -45,17 +45,36 @@ func NewTransfer(payload []byte, index int, conn io.Writer) Transfer { //Connect
 	}
 }
 
-// FreeSpace frees space in the slab's LRU cache by removing the least recently used node.
-func (s *SlabManager) FreeSpace(index, slabSize int) ([]byte, string) {
-	s.Lock()
-	defer s.Unlock()
+// Stats returns the counters of the slab manager, added up over every shard.
+func (s *SlabManager) Stats() *Stats {
+	total := &Stats{}
+	total.Add(&s.stats)
 
-	lastNode := s.lru[index].LastNode() // Get the last (least recently used) node
+	for i := range s.shards {
+		total.Add(&s.shards[i].stats)
+	}
 
-	s.lru[index].Delete(lastNode) // Delete the last node in the LRU cache
+	return total
+}
 
-	// Get free space from LRU after deleting the node
-	return s.lru[index].GetLRUFreeSpace(lastNode, slabSize), lastNode.GetKey()
+// Workers returns the number of worker goroutines.
+func (s *SlabManager) Workers() int {
+	return s.workers
+}
+
+// LimitMaxBytes returns the number of bytes the slabs can allocate.
+func (s *SlabManager) LimitMaxBytes() int {
+	return s.arena.Capacity()
+}
+
+// HashBytes returns the number of bytes of the hash tables of the shards, they count against the limit.
+func (s *SlabManager) HashBytes() int {
+	return s.arena.IndexBytes()
+}
+
+// MaxPayload returns the size of the largest request a chunk of the largest slab can hold.
+func (s *SlabManager) MaxPayload() int {
+	return s.slabs[len(s.slabs)-1].slabSize - frameOffset
 }
 
 // GetSlabIndex returns the slab at the specified index.
@@ -63,61 +82,170 @@ func (s *SlabManager) GetSlabIndex(index int) *Slab {
 	return &s.slabs[index]
 }
 
-// GetLRUIndex returns the LRU cache at the specified index.
-func (s *SlabManager) GetLRUIndex(index int) *link_list.DLL {
-	return &s.lru[index]
+// GetLRUIndex returns the eviction policy at the specified index.
+func (s *Shard) GetLRUIndex(index int) link_list.Policy {
+	return s.policy[index]
 }
 
 // NewSlabManager creates a new SlabManager with the provided slabs and starts worker goroutines.
+// Every slab evicts by the segmented LRU.
 func NewSlabManager(slabs []Slab, numberOfWorker int) *SlabManager {
+	return NewSlabManagerWithPolicy(slabs, numberOfWorker, func() link_list.Policy {
+		return link_list.NewSegmented()
+	})
+}
+
+// NewSlabManagerWithPolicy creates a new SlabManager whose slabs evict by the policies newPolicy creates,
+// one for every slab, and starts worker goroutines. All keys are in a single shard.
+func NewSlabManagerWithPolicy(slabs []Slab, numberOfWorker int, newPolicy func() link_list.Policy) *SlabManager {
+	return NewShardedSlabManager(slabs, numberOfWorker, 1, newPolicy)
+}
+
+// NewShardedSlabManager creates a new SlabManager whose keys are split into the given number of shards,
+// each with the policies newPolicy creates, one for every slab. The worker goroutines are spread
+// over the shards, every shard gets at least one. All slabs must share one allocator.
+func NewShardedSlabManager(slabs []Slab, numberOfWorker, shards int, newPolicy func() link_list.Policy) *SlabManager {
 	sm := &SlabManager{
-		slabs: slabs,
-		lru:   make([]link_list.DLL, len(slabs)), // Initialize LRU for each slab
-		JobCh: make(chan Transfer),               // Channel for receiving transfer jobs
+		slabs:  slabs,
+		shards: make([]Shard, max(shards, 1)),
+		seed:   maphash.MakeSeed(),
+		arena:  &Allocator{},
 	}
 
-	// Start a worker goroutine of numberOfWorker
-	for range numberOfWorker {
-		go sm.Worker()
+	if len(slabs) > 0 {
+		sm.arena = slabs[0].Allocator
 	}
 
+	for i := range sm.shards {
+		shard := &sm.shards[i]
+		shard.SlabManager = sm
+		shard.index = newIndex(sm.arena)
+		shard.policy = make([]link_list.Policy, len(slabs))
+		shard.jobs = make(chan Transfer) // Channel for receiving transfer jobs
+
+		for j := range shard.policy {
+			shard.policy[j] = newPolicy()
+		}
+
+		// The first shards take the workers that don't divide evenly
+		workers := numberOfWorker / len(sm.shards)
+		if i < numberOfWorker%len(sm.shards) {
+			workers++
+		}
+
+		for range max(workers, 1) {
+			go shard.Worker()
+		}
+
+		sm.workers += max(workers, 1)
+	}
+
+	go sm.Expirer()
+	go sm.LRUMaintainer()
+	go sm.PageReclaimer()
+
 	return sm
 }
 
 // GetSlab allocates a slab of memory based on the payload size, handles errors, and frees space if necessary.
-func (s *SlabManager) GetSlab(payloadSize int, conn net.Conn) ([]byte, int, error) {
-	slabIndex, chunkSize := s.GetIndex(payloadSize)
+func (s *SlabManager) GetSlab(payloadSize int) ([]byte, int, error) {
+	return s.GetSlabFor(payloadSize, 0, nil)
+}
+
+// GetSlabFor allocates a chunk for a request of the operation on the key and returns the memory the request
+// goes to, its frame ends where the item header does. If the admission filter is enabled and the request stores
+// an object, it may only evict an object used less often than the key. Otherwise the request is
+// rejected with ErrRejected and nothing is evicted.
+func (s *SlabManager) GetSlabFor(payloadSize int, operation byte, key []byte) ([]byte, int, error) {
+	slabIndex, chunkSize := s.GetIndex(frameOffset + payloadSize)
+
+	// The request can't fit even in the largest slab
+	if frameOffset+payloadSize > chunkSize {
+		return nil, -1, constants.ErrPayloadTooLarge
+	}
 
 	// Attempt to allocate memory from the chosen slab
 	slabBlock, err := s.ChoseSlab(slabIndex).AllocateMemory()
 	if err != nil {
-		// If memory allocation fails, handle the error
-		fmt.Println(err)
+		// If there is no more space in memory, the eviction policy frees up space
+		if slabBlock, err = s.evict(slabIndex, chunkSize, operation, key, err); err != nil {
+			return nil, -1, err
+		}
+	}
 
-		// If slab is inactive, notify the client and try to read the rest of the request
-		if !s.GetSlabIndex(slabIndex).IsSlabActive() {
-			conn.Write([]byte(err.Error()))
+	return slabBlock[frameOffset:], slabIndex, nil
+}
 
-			// and if I can't allocate memory to my server I still have to read the req to the end
-			_, err := conn.Read(constants.NoReq)
-			if err != nil {
-				return nil, -1, err
-			}
+// evict frees a chunk of the slab by evicting an object of the shard of the key. A shard without objects
+// in the slab leaves the eviction to the next one, a request without a key starts at the next shard in turn.
+// It returns err if no shard has anything to evict.
+func (s *SlabManager) evict(slabIndex, chunkSize int, operation byte, key []byte, err error) ([]byte, error) {
+	start := int(s.next.Add(1) % uint64(len(s.shards)))
+	if key != nil {
+		start = s.shardIndex(key)
+	}
+
+	for i := range s.shards {
+		shard := &s.shards[(start+i)%len(s.shards)]
+
+		shard.Lock()
+		item := shard.victim(slabIndex)
+
+		// Nothing to evict, the shard has no object in the slab
+		if item == nil {
+			shard.Unlock()
+			continue
+		}
+
+		if s.admission != nil && NeedsAdmission(operation) && !s.admission.Admit(s.hash(key), item.Hash()) {
+			shard.Unlock()
+			shard.stats.Rejected.Add(1)
+			return nil, constants.ErrRejected
+		}
+
+		shard.unlink(item)
+		shard.Unlock()
+
+		shard.stats.Evictions.Add(1)
+		s.slabs[slabIndex].evictions.Add(1)
+		return item.chunk(chunkSize), nil
+	}
+
+	// Nothing to evict, the slab never got a page of its own
+	s.slabs[slabIndex].outOfMemory.Add(1)
+	return nil, err
+}
+
+// victim returns the object the policy of the slab evicts next, nil if the shard has none in the slab.
+// Objects a response still reads are evicted on the way, their chunks are freed once the responses are written.
+// The caller must hold the shard lock.
+func (s *Shard) victim(slabIndex int) *Item {
+	for node := s.policy[slabIndex].LastNode(); node != nil; node = s.policy[slabIndex].LastNode() {
+		item := (*Item)(unsafe.Pointer(node))
+		if !item.isShared() {
+			return item
 		}
 
-		// If there is no more space in memory, uses LRU
-		// (Least Recently Used) policy to free up space.
-		s.Lock()
-		lastNode := s.lru[slabIndex].LastNode()                           // Get the last LRU node
-		s.lru[slabIndex].Delete(lastNode)                                 // Delete last node in
-		slabBlock = s.lru[slabIndex].GetLRUFreeSpace(lastNode, chunkSize) // Get free space after deleting the node
-		s.Unlock()
+		s.unlink(item)
+		s.unref(item)
 
-		// Deletes the key from the hash table.
-		s.store.Delete(lastNode.GetKey())
+		s.stats.Evictions.Add(1)
+		s.slabs[slabIndex].evictions.Add(1)
 	}
 
-	return slabBlock, slabIndex, nil
+	return nil
+}
+
+// StatusOf maps an allocation error to the status code reported to the client.
+func StatusOf(err error) byte {
+	switch err {
+	case constants.ErrPayloadTooLarge:
+		return constants.StatusTooLarge
+	case constants.ErrRejected:
+		return constants.StatusRejected
+	}
+
+	return constants.StatusNotEnoughSpace
 }
 
 // TLLParser converts a TTL value into a time.Time object.
@@ -130,6 +258,7 @@ func TLLParser(ttl uint32) time.Time {
 }
 
 // GetIndex performs a binary search to find the appropriate slab index based on the data size.
+// The slabs must be sorted by chunk size, which can be any size, not only a power of two.
 func (s *SlabManager) GetIndex(dataSize int) (int, int) {
 	low, high := 0, len(s.slabs)-1
 	result := high
@@ -160,10 +289,74 @@ type Slab struct {
 	freeList     stack.Stack[unsafe.Pointer] // Stack of free blocks in the slab
 	currentPage  []byte                      // Current memory page in the slab
 	pagePointer  int                         // Pointer to the current position in the slab
+	pages        [][]byte                    // Pages taken from the allocator or moved from other slabs
+	maxPages     int                         // Number of pages the slab may take, 0 for no limit
+	used         int                         // Number of chunks handed out and not freed
+	evictions    atomic.Uint64               // Number of objects evicted to reuse their chunk
+	outOfMemory  atomic.Uint64               // Number of requests refused because nothing could be evicted
 	sync.RWMutex                             // Mutex to protect access to the slab
 	*Allocator                               // Memory allocator associated with the slab
 }
 
+// SlabStat is the state of a slab class reported by the stats slabs command.
+type SlabStat struct {
+	ChunkSize   int    // Size of a chunk
+	Pages       int    // Number of pages taken from the allocator
+	MaxPages    int    // Number of pages the class may take, 0 for no limit
+	UsedChunks  int    // Number of chunks holding data
+	FreeChunks  int    // Number of chunks that can be handed out without a new page
+	Evictions   uint64 // Number of objects evicted from the class
+	OutOfMemory uint64 // Number of requests refused because the class had nothing to evict
+	Items       int    // Number of objects in the eviction policy
+	Hot         int    // Number of objects in the hot segment of the LRU
+	Warm        int    // Number of objects in the warm segment of the LRU
+	Cold        int    // Number of objects in the cold segment of the LRU
+}
+
+// Stat returns the current state of the slab.
+func (s *Slab) Stat() SlabStat {
+	s.RLock()
+	defer s.RUnlock()
+
+	free := s.freeList.Len()
+	if s.currentPage != nil {
+		free += (len(s.currentPage) - s.pagePointer) / s.slabSize
+	}
+
+	return SlabStat{
+		ChunkSize:   s.slabSize,
+		Pages:       len(s.pages),
+		MaxPages:    s.maxPages,
+		UsedChunks:  s.used,
+		FreeChunks:  free,
+		Evictions:   s.evictions.Load(),
+		OutOfMemory: s.outOfMemory.Load(),
+	}
+}
+
+// SlabStats returns the state of every slab class, in order of chunk size.
+func (s *SlabManager) SlabStats() []SlabStat {
+	stats := make([]SlabStat, len(s.slabs))
+	for i := range s.slabs {
+		stats[i] = s.slabs[i].Stat()
+
+		for j := range s.shards {
+			policy := s.shards[j].policy[i]
+			stats[i].Items += policy.Len()
+
+			// Only the segmented LRU has segments
+			if segmented, ok := policy.(*link_list.Segmented); ok {
+				hot, warm, cold := segmented.Segments()
+				stats[i].Hot += hot
+				stats[i].Warm += warm
+				stats[i].Cold += cold
+			}
+		}
+	}
+
+	return stats
+}
+
 // IsSlabActive checks if the slab has an active memory page.
 func (s *Slab) IsSlabActive() bool {
 	return s.currentPage != nil
@@ -174,15 +367,24 @@ func (s *Slab) GetCurrentPage() []byte {
 	return s.currentPage
 }
 
-// NewSlab creates a new Slab with the specified size and allocator.
+// NewSlab creates a new Slab with the specified size and allocator. The size is raised to MinimumChunkSize,
+// which holds the item header, and rounded up to a multiple of ChunkAlign, so the header is aligned.
+// maxMemoryAllocate limits the memory of the slab in MiB, which is one page each; 0 means no limit.
 func NewSlab(slabSize, maxMemoryAllocate int, allocator *Allocator) Slab {
 	return Slab{
-		slabSize:  slabSize,
+		slabSize:  (max(slabSize, constants.MinimumChunkSize) + constants.ChunkAlign - 1) / constants.ChunkAlign * constants.ChunkAlign,
 		freeList:  stack.New[unsafe.Pointer](10),
+		maxPages:  max(maxMemoryAllocate, 0),
 		Allocator: allocator,
 	}
 }
 
+// IsFull reports whether the slab took all the pages its memory limit allows.
+// The caller must hold the slab lock.
+func (s *Slab) IsFull() bool {
+	return s.maxPages > 0 && len(s.pages) >= s.maxPages
+}
+
 // AllocateMemory allocates memory for the slab, either by reusing a free block or allocating a new page.
 func (s *Slab) AllocateMemory() ([]byte, error) {
 	s.Lock()
@@ -191,6 +393,8 @@ func (s *Slab) AllocateMemory() ([]byte, error) {
 	// Try to pop from the free list if there are free blocks
 	if !s.freeList.IsEmpty() {
 		ptr, err := s.freeList.Pop()
+		s.claim(ptr)
+		s.used++
 		return unsafe.Slice((*byte)(ptr), s.slabSize), err
 	}
 
@@ -200,6 +404,11 @@ func (s *Slab) AllocateMemory() ([]byte, error) {
 
 	// If no active page or insufficient space, allocate a new page
 	if s.currentPage == nil || !IsEnoughSpace(end, len(s.currentPage)) {
+		// The slab used its budget, the caller evicts from its own LRU instead
+		if s.IsFull() {
+			return nil, constants.ErrSlabLimit
+		}
+
 		block, err := s.AllocateBlock()
 		if err != nil {
 			return nil, err
@@ -207,13 +416,46 @@ func (s *Slab) AllocateMemory() ([]byte, error) {
 
 		// Update the current page with the new block
 		s.UpdatePage(block)
+		s.pages = append(s.pages, block)
+		s.claim(unsafe.Pointer(&block[0]))
+		s.used++
+		s.pagePointer = s.slabSize
 		return s.currentPage[0:s.slabSize], nil //new memory block
 	}
 
 	// Return the allocated memory block from the current page
+	s.claim(unsafe.Pointer(&s.currentPage[start]))
+	s.used++
+	s.pagePointer = end
 	return s.currentPage[start:end], nil
 }
 
+// Free returns a chunk of the slab to its free list so it can be reused.
+func (s *Slab) Free(ptr unsafe.Pointer) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.freeList.Push(ptr)
+	s.reclaim(ptr)
+	s.used--
+}
+
+// trimPages gives the memory of the pages whose chunks stayed free since the previous call back to the OS.
+// It returns the number of pages trimmed.
+func (s *Slab) trimPages() int {
+	s.Lock()
+	defer s.Unlock()
+
+	trimmed := 0
+	for _, page := range s.pages {
+		if s.trim(page) {
+			trimmed++
+		}
+	}
+
+	return trimmed
+}
+
 func (s *Slab) UpdatePage(dataBlock []byte) {
 	s.currentPage = dataBlock
 	s.pagePointer = 0
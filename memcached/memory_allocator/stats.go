@@ -0,0 +1,107 @@
+package memory_allocator
+
+import "sync/atomic"
+
+// Stats holds the counters reported by the stats command.
+type Stats struct {
+	CmdGet         atomic.Uint64 // Number of get requests
+	GetHits        atomic.Uint64 // Number of keys found
+	GetMisses      atomic.Uint64 // Number of keys not found
+	GetExpired     atomic.Uint64 // Number of keys found, but expired
+	CmdSet         atomic.Uint64 // Number of store requests
+	CmdTouch       atomic.Uint64 // Number of touch requests
+	TouchHits      atomic.Uint64 // Number of keys touched
+	TouchMisses    atomic.Uint64 // Number of touch requests for missing keys
+	DeleteHits     atomic.Uint64 // Number of keys deleted
+	DeleteMisses   atomic.Uint64 // Number of delete requests for missing keys
+	IncrHits       atomic.Uint64 // Number of keys incremented
+	IncrMisses     atomic.Uint64 // Number of increment requests for missing keys
+	DecrHits       atomic.Uint64 // Number of keys decremented
+	DecrMisses     atomic.Uint64 // Number of decrement requests for missing keys
+	CasHits        atomic.Uint64 // Number of keys stored by a matching CAS value
+	CasMisses      atomic.Uint64 // Number of CAS requests for missing keys
+	CasBadval      atomic.Uint64 // Number of CAS requests refused because the object changed
+	Evictions      atomic.Uint64 // Number of objects removed to free memory
+	Reclaimed      atomic.Uint64 // Number of expired objects removed by the expirer
+	SlabsMoved     atomic.Uint64 // Number of pages moved between slab classes
+	SlabRescues    atomic.Uint64 // Number of objects moved out of a page given to another class
+	SlabReassigned atomic.Uint64 // Number of objects evicted from a page given to another class
+	PagesReleased  atomic.Uint64 // Number of pages given back to the allocator by a lower memory limit
+	MovesToWarm    atomic.Uint64 // Number of objects the LRU maintainer moved to a warm segment
+	MovesToCold    atomic.Uint64 // Number of objects the LRU maintainer moved to a cold segment
+	Rejected       atomic.Uint64 // Number of objects the admission filter didn't let evict
+	TotalItems     atomic.Uint64 // Number of objects stored since the start
+	CurrItems      atomic.Int64  // Number of objects currently stored
+}
+
+// Add adds the counters of other to the counters of s.
+func (s *Stats) Add(other *Stats) {
+	s.CmdGet.Add(other.CmdGet.Load())
+	s.GetHits.Add(other.GetHits.Load())
+	s.GetMisses.Add(other.GetMisses.Load())
+	s.GetExpired.Add(other.GetExpired.Load())
+	s.CmdSet.Add(other.CmdSet.Load())
+	s.CmdTouch.Add(other.CmdTouch.Load())
+	s.TouchHits.Add(other.TouchHits.Load())
+	s.TouchMisses.Add(other.TouchMisses.Load())
+	s.DeleteHits.Add(other.DeleteHits.Load())
+	s.DeleteMisses.Add(other.DeleteMisses.Load())
+	s.IncrHits.Add(other.IncrHits.Load())
+	s.IncrMisses.Add(other.IncrMisses.Load())
+	s.DecrHits.Add(other.DecrHits.Load())
+	s.DecrMisses.Add(other.DecrMisses.Load())
+	s.CasHits.Add(other.CasHits.Load())
+	s.CasMisses.Add(other.CasMisses.Load())
+	s.CasBadval.Add(other.CasBadval.Load())
+	s.Evictions.Add(other.Evictions.Load())
+	s.Reclaimed.Add(other.Reclaimed.Load())
+	s.SlabsMoved.Add(other.SlabsMoved.Load())
+	s.SlabRescues.Add(other.SlabRescues.Load())
+	s.SlabReassigned.Add(other.SlabReassigned.Load())
+	s.PagesReleased.Add(other.PagesReleased.Load())
+	s.MovesToWarm.Add(other.MovesToWarm.Load())
+	s.MovesToCold.Add(other.MovesToCold.Load())
+	s.Rejected.Add(other.Rejected.Load())
+	s.TotalItems.Add(other.TotalItems.Load())
+	s.CurrItems.Add(other.CurrItems.Load())
+}
+
+// Stat is a single named statistic.
+type Stat struct {
+	Name  string
+	Value uint64
+}
+
+// Snapshot returns the current value of every counter, in the order they are reported.
+func (s *Stats) Snapshot() []Stat {
+	return []Stat{
+		{"curr_items", uint64(max(s.CurrItems.Load(), 0))},
+		{"total_items", s.TotalItems.Load()},
+		{"cmd_get", s.CmdGet.Load()},
+		{"cmd_set", s.CmdSet.Load()},
+		{"cmd_touch", s.CmdTouch.Load()},
+		{"get_hits", s.GetHits.Load()},
+		{"get_misses", s.GetMisses.Load()},
+		{"get_expired", s.GetExpired.Load()},
+		{"delete_hits", s.DeleteHits.Load()},
+		{"delete_misses", s.DeleteMisses.Load()},
+		{"incr_hits", s.IncrHits.Load()},
+		{"incr_misses", s.IncrMisses.Load()},
+		{"decr_hits", s.DecrHits.Load()},
+		{"decr_misses", s.DecrMisses.Load()},
+		{"cas_misses", s.CasMisses.Load()},
+		{"cas_hits", s.CasHits.Load()},
+		{"cas_badval", s.CasBadval.Load()},
+		{"touch_hits", s.TouchHits.Load()},
+		{"touch_misses", s.TouchMisses.Load()},
+		{"evictions", s.Evictions.Load()},
+		{"reclaimed", s.Reclaimed.Load()},
+		{"slabs_moved", s.SlabsMoved.Load()},
+		{"slab_reassign_rescues", s.SlabRescues.Load()},
+		{"slab_reassign_evictions", s.SlabReassigned.Load()},
+		{"pages_released", s.PagesReleased.Load()},
+		{"moves_to_warm", s.MovesToWarm.Load()},
+		{"moves_to_cold", s.MovesToCold.Load()},
+		{"admission_rejected", s.Rejected.Load()},
+	}
+}
@@ -1,12 +1,13 @@
 package memory_allocator
 
 import (
+	"io"
 	"log"
+	"strconv"
 	"time"
 	"unsafe"
 
 	"github.com/WatchJani/memCashed/memcached/constants"
-	"github.com/WatchJani/memCashed/memcached/link_list"
 	decoder "github.com/WatchJani/memCashed/memcached/parser"
 )
 
@@ -14,24 +15,15 @@ func ParseOperation(payload []byte) byte {
 	return payload[0]
 }
 
-// Worker listens for transfer jobs and processes them based on the payload command.
-func (s *SlabManager) Worker() {
-	for payload := range s.JobCh {
-		switch ParseOperation(payload.payload) {
-		case constants.SetOperation: // Command to store data
-			s.SetOperationFn(payload)
-		case constants.GetOperation: // Command to get data
-			s.GetOperationFn(payload)
-		case constants.DeleteOperation: // Command to delete data
-			s.DeleteOperationFn(payload)
-		default:
-			log.Println(constants.ErrOperationIsNotSupported)
-		}
+// Worker listens for transfer jobs of the shard and processes them based on the payload command.
+func (s *Shard) Worker() {
+	for payload := range s.jobs {
+		s.chooseOperation(payload)
 	}
 }
 
-// test usage
-func (s *SlabManager) chooseOperation(payload Transfer) {
+// chooseOperation runs the operation requested in the payload header.
+func (s *Shard) chooseOperation(payload Transfer) {
 	switch ParseOperation(payload.payload) {
 	case constants.SetOperation: // Command to store data
 		s.SetOperationFn(payload)
@@ -39,93 +31,809 @@ func (s *SlabManager) chooseOperation(payload Transfer) {
 		s.GetOperationFn(payload)
 	case constants.DeleteOperation: // Command to delete data
 		s.DeleteOperationFn(payload)
+	case constants.AddOperation: // Command to store data only if the key is absent
+		s.AddOperationFn(payload)
+	case constants.ReplaceOperation: // Command to store data only if the key is present
+		s.ReplaceOperationFn(payload)
+	case constants.TouchOperation: // Command to update the TTL
+		s.TouchOperationFn(payload)
+	case constants.GetAndTouchOperation: // Command to get data and update the TTL
+		s.GetAndTouchOperationFn(payload)
+	case constants.CompareAndSetOperation: // Command to store data only if it wasn't modified
+		s.CompareAndSetOperationFn(payload)
+	case constants.AppendOperation: // Command to add data after the stored value
+		s.AppendOperationFn(payload)
+	case constants.PrependOperation: // Command to add data before the stored value
+		s.PrependOperationFn(payload)
+	case constants.IncrementOperation: // Command to increment a counter
+		s.IncrementOperationFn(payload)
+	case constants.DecrementOperation: // Command to decrement a counter
+		s.DecrementOperationFn(payload)
+	case constants.MetaGetOperation: // Command to get data with its metadata
+		s.MetaGetOperationFn(payload)
+	case constants.PeekOperation: // Command to get the metadata without recording a read
+		s.PeekOperationFn(payload)
+	case constants.MultiGetOperation: // Command to get many keys at once
+		s.MultiGetOperationFn(payload)
+	case constants.MultiSetOperation: // Command to store many objects at once
+		s.MultiSetOperationFn(payload)
+	case constants.MultiDeleteOperation: // Command to delete many keys at once
+		s.MultiDeleteOperationFn(payload)
 	default:
 		log.Println(constants.ErrOperationIsNotSupported)
+		Respond(payload.conn, decoder.RequestID(payload.payload), constants.StatusUnsupported, nil)
 	}
 }
 
-func (s *SlabManager) SetOperationFn(payload Transfer) {
-	_, keySize, ttl, bodySize := decoder.Decode(payload.payload) // Decode the payload
+// Respond writes a response frame with the given status and body to the connection,
+// echoing the request id so the client can match the reply to its request.
+func Respond(conn io.Writer, id uint32, status byte, body []byte) {
+	RespondItem(conn, id, status, nil, body)
+}
 
-	bodyOffset := constants.HeaderSize + keySize
-	key := string(payload.payload[constants.HeaderSize:bodyOffset]) // Extract key from the payload
+// RespondItem writes a response frame carrying the flags and CAS value of the object, zero for a nil object.
+// The caller must hold a reference to the object.
+func RespondItem(conn io.Writer, id uint32, status byte, item *Item, body []byte) {
+	var flags uint32
+	var cas uint64
+	if item != nil {
+		flags, cas = item.flags, item.cas
+	}
+
+	if _, err := conn.Write(decoder.EncodeResponse(status, flags, id, cas, body)); err != nil {
+		log.Println(err) // Log any errors that occur while writing to the connection
+	}
+}
+
+// reply writes a response frame for the object and drops the reference the caller held to it.
+func (s *Shard) reply(conn io.Writer, id uint32, status byte, item *Item, body []byte) {
+	RespondItem(conn, id, status, item, body)
+	s.unref(item)
+}
+
+// insert stores the object from the payload in the LRU cache and the hash table. The item header is written
+// over the header of the request, the key and the value stay where the request put them. It returns
+// the object with a reference held for the caller. The caller must hold the shard lock.
+func (s *Shard) insert(payload Transfer) *Item {
+	// The request header is decoded before the item header overwrites it
+	_, keySize, ttl, bodySize := decoder.Decode(payload.payload)
+	flags := decoder.Flags(payload.payload)
+	hash := s.hash(payload.payload[constants.HeaderSize : constants.HeaderSize+keySize])
+
+	item := itemOf(payload.payload)
+	item.expires(TLLParser(ttl))
+	item.cas = s.cas.Add(1)
+	item.access.Store(uint32(time.Now().Unix()))
+	item.flags = flags
+	item.size = bodySize
+	item.refs.Store(2) // The index and the caller
+	item.fetched.Store(false)
+	item.keySize = uint8(keySize)
+	item.class = uint8(payload.index)
 
 	// Insert the key into the LRU cache
-	node := s.lru[payload.index].Inset(link_list.NewValue(unsafe.Pointer(&payload.payload[0]), key))
+	s.policy[payload.index].Inset(&item.Node, hash)
 
-	// Store the key-value pair in the store with TTL
+	// Store the object in the hash table, an overwritten object gives back its memory
+	if previous := s.index.put(item); previous != nil {
+		s.release(previous)
+	} else {
+		s.stats.CurrItems.Add(1)
+	}
 
-	s.store.Store(key, Key{
-		field:   payload.payload[bodyOffset : bodyOffset+bodySize],
-		ttl:     TLLParser(ttl),
-		pointer: node,
-	})
+	s.schedule(item)
 
-	if _, err := payload.conn.Write(constants.ObjectInserted); err != nil {
-		log.Println(err) // Log any errors that occur while writing to the connection
+	s.stats.TotalItems.Add(1)
+	return item
+}
+
+// find returns the object stored under the key, nil if there is none.
+// The caller must hold the shard lock, at least for reading.
+func (s *Shard) find(key string) *Item {
+	return s.index.find(s.hashString(key), key)
+}
+
+// load returns the object stored under the key, an expired object is removed and reported as missing.
+// The caller must hold the shard lock.
+func (s *Shard) load(key string) *Item {
+	item := s.find(key)
+	if item == nil {
+		return nil
+	}
+
+	if item.IsExpired() {
+		s.drop(item)
+		return nil
+	}
+
+	return item
+}
+
+// drop deletes an object the shard holds from the hash table and the LRU cache, its chunk is freed
+// once no response reads it anymore. The caller must hold the shard lock.
+func (s *Shard) drop(item *Item) {
+	s.unlink(item)
+	s.unref(item)
+}
+
+// unlink deletes an object from the hash table and the LRU cache, keeping the reference of the index
+// for the caller. The caller must hold the shard lock.
+func (s *Shard) unlink(item *Item) {
+	s.policy[item.class].Delete(&item.Node) // Remove the node from LRU
+
+	if s.index.remove(item) {
+		s.stats.CurrItems.Add(-1)
 	}
 }
 
-func (s *SlabManager) GetOperationFn(payload Transfer) {
+// release removes the node of an object that is no longer stored from the LRU cache and drops
+// the reference of the index. The caller must hold the shard lock.
+func (s *Shard) release(item *Item) {
+	s.policy[item.class].Delete(&item.Node) // Remove the node from LRU
+	s.unref(item)
+}
+
+// free gives the chunk of a request that isn't stored back to its slab class.
+func (s *SlabManager) free(payload Transfer) {
+	s.slabs[payload.index].Free(unsafe.Pointer(itemOf(payload.payload)))
+}
+
+func (s *Shard) SetOperationFn(payload Transfer) {
+	id := decoder.RequestID(payload.payload)
+	s.stats.CmdSet.Add(1)
+
+	s.Lock()
+	item := s.insert(payload)
+	s.Unlock()
+
+	s.reply(payload.conn, id, constants.StatusStored, item, nil)
+}
+
+func (s *Shard) AddOperationFn(payload Transfer) {
+	s.conditionalSet(payload, false)
+}
+
+func (s *Shard) ReplaceOperationFn(payload Transfer) {
+	s.conditionalSet(payload, true)
+}
+
+// conditionalSet stores the object only if the key is present (replace) or absent (add).
+// The check and the store happen under the shard lock, so they are atomic.
+func (s *Shard) conditionalSet(payload Transfer, present bool) {
 	_, keySize, _, _ := decoder.Decode(payload.payload)                                 // Decode the payload
 	key := string(payload.payload[constants.HeaderSize : constants.HeaderSize+keySize]) // Extract key from the payload
+	id := decoder.RequestID(payload.payload)
+	s.stats.CmdSet.Add(1)
 
-	s.slabs[payload.index].freeList.Push(unsafe.Pointer(&payload.payload[0])) //delete our header space
+	s.Lock()
+	if isFound := s.load(key) != nil; isFound != present {
+		s.Unlock()
 
-	// Fetch the value from the store
-	valueObject, isFound := s.store.Load(key)
-	if !isFound {
-		if _, err := payload.conn.Write(constants.ErrObjectNotFound); err != nil {
-			log.Println(err)
+		s.free(payload) // the object is not stored
+		Respond(payload.conn, id, constants.StatusNotStored, nil)
+		return
+	}
+
+	item := s.insert(payload)
+	s.Unlock()
+
+	s.reply(payload.conn, id, constants.StatusStored, item, nil)
+}
+
+// CompareAndSetOperationFn stores the object only if the CAS value in front of the body still matches
+// the stored object, so a read-modify-write can't overwrite a change made in between.
+func (s *Shard) CompareAndSetOperationFn(payload Transfer) {
+	_, keySize, _, bodySize := decoder.Decode(payload.payload) // Decode the payload
+
+	bodyOffset := constants.HeaderSize + keySize
+	key := string(payload.payload[constants.HeaderSize:bodyOffset]) // Extract key from the payload
+	id := decoder.RequestID(payload.payload)
+	s.stats.CmdSet.Add(1)
+
+	if bodySize < constants.CASSize {
+		s.free(payload)
+		Respond(payload.conn, id, constants.StatusUnsupported, nil)
+		return
+	}
+
+	token := decoder.LittleEndianDecode64(payload.payload[bodyOffset : bodyOffset+constants.CASSize])
+
+	// Drop the CAS value, so the chunk holds the object the same way a set request does
+	copy(payload.payload[bodyOffset:], payload.payload[bodyOffset+constants.CASSize:bodyOffset+bodySize])
+	decoder.SetBodyLength(payload.payload, bodySize-constants.CASSize)
+
+	s.Lock()
+	current := s.load(key)
+	if current == nil || current.cas != token {
+		s.Unlock()
+
+		s.free(payload) // the object is not stored
+
+		if current == nil {
+			s.stats.CasMisses.Add(1)
+			Respond(payload.conn, id, constants.StatusNotFound, nil)
+		} else {
+			s.stats.CasBadval.Add(1)
+			Respond(payload.conn, id, constants.StatusExists, nil)
 		}
+
+		return
+	}
+
+	item := s.insert(payload)
+	s.Unlock()
+
+	s.stats.CasHits.Add(1)
+	s.reply(payload.conn, id, constants.StatusStored, item, nil)
+}
+
+func (s *Shard) GetOperationFn(payload Transfer) {
+	id := decoder.RequestID(payload.payload)
+
+	item, status := s.get(payload)
+	if status != constants.StatusOK {
+		Respond(payload.conn, id, status, nil)
+		return
+	}
+
+	// Return the field data if found
+	item.Access()
+	s.reply(payload.conn, id, constants.StatusOK, item, item.Value())
+}
+
+// MetaGetOperationFn returns the object together with the metadata reported by the meta protocol:
+// TTL remaining, seconds since the last access and whether it was read before.
+func (s *Shard) MetaGetOperationFn(payload Transfer) {
+	id := decoder.RequestID(payload.payload)
+
+	item, status := s.get(payload)
+	if status != constants.StatusOK {
+		Respond(payload.conn, id, status, nil)
+		return
+	}
+
+	access, fetched := item.Access()
+	value := item.Value()
+
+	body := make([]byte, constants.MetaSize+len(value))
+	decoder.EncodeMeta(body, item.TTLRemaining(), time.Now().Unix()-access, fetched)
+	copy(body[constants.MetaSize:], value)
+
+	s.reply(payload.conn, id, constants.StatusOK, item, body)
+}
+
+// PeekOperationFn returns the metadata of the object like a meta get does, without its value. It leaves
+// the statistics, the place of the object in the eviction order, its last access and hit before as they are.
+func (s *Shard) PeekOperationFn(payload Transfer) {
+	_, keySize, _, _ := decoder.Decode(payload.payload)                                 // Decode the payload
+	key := string(payload.payload[constants.HeaderSize : constants.HeaderSize+keySize]) // Extract key from the payload
+	id := decoder.RequestID(payload.payload)
+
+	s.free(payload) //delete our header space
+
+	// An expired object is left for the expirer, removing it would take the write lock
+	s.RLock()
+	item := s.index.find(s.hashString(key), key)
+	if item == nil || item.IsExpired() {
+		s.RUnlock()
+
+		Respond(payload.conn, id, constants.StatusNotFound, nil)
 		return
 	}
 
-	value := valueObject.(Key)
+	item.acquire()
+	s.RUnlock()
+
+	access, fetched := item.accessed()
+
+	body := make([]byte, constants.MetaSize)
+	decoder.EncodeMeta(body, item.TTLRemaining(), time.Now().Unix()-access, fetched)
+
+	s.reply(payload.conn, id, constants.StatusOK, item, body)
+}
+
+// get looks up the key of a get request and refreshes its place in the LRU cache.
+// It frees the chunk of the request and returns the status to report if the object can't be read.
+func (s *Shard) get(payload Transfer) (*Item, byte) {
+	_, keySize, _, _ := decoder.Decode(payload.payload)                                 // Decode the payload
+	key := string(payload.payload[constants.HeaderSize : constants.HeaderSize+keySize]) // Extract key from the payload
+
+	s.free(payload) //delete our header space
+
+	return s.lookup(key)
+}
+
+// lookup fetches the object stored under the key and refreshes its place in the LRU cache. It returns
+// the object with a reference held for the caller, who records the access.
+func (s *Shard) lookup(key string) (*Item, byte) {
+	hash := s.hashString(key)
+
+	s.stats.CmdGet.Add(1)
+	s.record(hash)
+
+	// Fetch the value from the hash table
+	s.RLock()
+	item := s.index.find(hash, key)
+	if item == nil {
+		s.RUnlock()
+
+		s.stats.GetMisses.Add(1)
+		return nil, constants.StatusNotFound
+	}
 
 	// Check if the TTL has expired and delete the object if expired
-	if !value.ttl.IsZero() && time.Now().After(value.ttl) {
-		s.store.Delete(key)
-		s.lru[payload.index].Delete(value.pointer) // Remove the node from LRU
-		memoryPointer := value.pointer.GetPointer()
-		s.slabs[payload.index].freeList.Push(memoryPointer)
-
-		if _, err := payload.conn.Write(constants.ErrTimeExpire); err != nil {
-			log.Println(err)
+	if item.IsExpired() {
+		s.RUnlock()
+
+		s.Lock()
+		s.load(key) // Removes the object, unless it was stored again in the meantime
+		s.Unlock()
+
+		s.stats.GetExpired.Add(1)
+		s.stats.GetMisses.Add(1)
+		return nil, constants.StatusExpired
+	}
+
+	// The chunk may hold another object once the lock is released, unless the reference keeps it
+	s.policy[item.class].Read(&item.Node)
+	item.acquire()
+	s.RUnlock()
+
+	s.stats.GetHits.Add(1)
+	return item, constants.StatusOK
+}
+
+// MultiGetOperationFn looks up every key listed in the body and replies with one entry per key,
+// in the order of the request. Misses are reported by the status of their entry. Every key is looked up in its own shard.
+func (s *Shard) MultiGetOperationFn(payload Transfer) {
+	id := decoder.RequestID(payload.payload)
+
+	keys, ok := s.keys(payload)
+	if !ok {
+		Respond(payload.conn, id, constants.StatusUnsupported, nil)
+		return
+	}
+
+	var response []byte
+	for _, key := range keys {
+		shard := s.shard(key)
+
+		item, status := shard.lookup(key)
+		if status != constants.StatusOK {
+			response = decoder.AppendEntry(response, status, 0, 0, nil)
+			continue
 		}
+
+		item.Access()
+		response = decoder.AppendEntry(response, constants.StatusOK, item.flags, item.cas, item.Value())
+		shard.unref(item)
+	}
+
+	Respond(payload.conn, id, constants.StatusOK, response)
+}
+
+// MultiSetOperationFn stores every item listed in the body and replies with one status byte per item,
+// in the order of the request. Every item gets a chunk of its own slab class, like a set request.
+func (s *Shard) MultiSetOperationFn(payload Transfer) {
+	_, keySize, _, bodySize := decoder.Decode(payload.payload) // Decode the payload
+
+	bodyOffset := constants.HeaderSize + keySize
+	id := decoder.RequestID(payload.payload)
+
+	var statuses []byte
+	for body := payload.payload[bodyOffset : bodyOffset+bodySize]; len(body) > 0; {
+		key, value, ttl, flags, rest, ok := decoder.NextItem(body)
+		if !ok {
+			s.free(payload)
+			Respond(payload.conn, id, constants.StatusUnsupported, nil)
+			return
+		}
+
+		statuses, body = append(statuses, s.shard(string(key)).storeItem(key, value, ttl, flags)), rest
+	}
+
+	s.free(payload) //delete our header space
+	Respond(payload.conn, id, constants.StatusOK, statuses)
+}
+
+// storeItem copies one item of a multi set request into a chunk of its slab class and stores it.
+// The shard must be the one holding the key.
+func (s *Shard) storeItem(key, value []byte, ttl, flags uint32) byte {
+	s.stats.CmdSet.Add(1)
+
+	item, status := s.allocate(key, value, ttl, flags)
+	if status != constants.StatusStored {
+		return status
+	}
+
+	s.Lock()
+	stored := s.insert(item)
+	s.Unlock()
+
+	s.unref(stored)
+	return constants.StatusStored
+}
+
+// allocate copies an object into a chunk of its slab class, laid out the way a set request is,
+// and returns it ready to be inserted. It returns the status to report if there is no chunk for it.
+// The caller must not hold a shard lock.
+func (s *Shard) allocate(key, value []byte, ttl, flags uint32) (Transfer, byte) {
+	chunk, index, err := s.GetSlabFor(constants.HeaderSize+len(key)+len(value), constants.SetOperation, key)
+	if err != nil {
+		return Transfer{}, StatusOf(err)
+	}
+
+	n := decoder.EncodeInto(chunk, constants.SetOperation, key, value, int(ttl), flags)
+	return NewTransfer(chunk[:n], index, nil), constants.StatusStored
+}
+
+// MultiDeleteOperationFn deletes every key listed in the body and replies with one status byte per key,
+// in the order of the request.
+func (s *Shard) MultiDeleteOperationFn(payload Transfer) {
+	id := decoder.RequestID(payload.payload)
+
+	keys, ok := s.keys(payload)
+	if !ok {
+		Respond(payload.conn, id, constants.StatusUnsupported, nil)
 		return
 	}
-	s.lru[payload.index].Read(value.pointer)
 
-	// Return the field data if found
-	if _, err := payload.conn.Write(value.field); err != nil {
-		log.Println(err)
+	statuses := make([]byte, len(keys))
+	for i, key := range keys {
+		statuses[i] = s.shard(key).delete(key)
+	}
+
+	Respond(payload.conn, id, constants.StatusOK, statuses)
+}
+
+// keys copies the keys listed in the body of a multi key request and frees the chunk of the request.
+// It reports false if the body is malformed.
+func (s *Shard) keys(payload Transfer) ([]string, bool) {
+	_, keySize, _, bodySize := decoder.Decode(payload.payload) // Decode the payload
+	bodyOffset := constants.HeaderSize + keySize
+
+	defer s.free(payload) //delete our header space
+
+	var keys []string
+	for body := payload.payload[bodyOffset : bodyOffset+bodySize]; len(body) > 0; {
+		key, rest, ok := decoder.NextKey(body)
+		if !ok {
+			return nil, false
+		}
+
+		keys, body = append(keys, string(key)), rest
 	}
+
+	return keys, true
 }
 
-func (s *SlabManager) DeleteOperationFn(payload Transfer) {
+func (s *Shard) DeleteOperationFn(payload Transfer) {
 	_, keySize, _, _ := decoder.Decode(payload.payload)                                 // Decode the payload
 	key := string(payload.payload[constants.HeaderSize : constants.HeaderSize+keySize]) // Extract key from the payload
+	id := decoder.RequestID(payload.payload)
+
+	s.free(payload) //delete our header space
+
+	Respond(payload.conn, id, s.delete(key), nil)
+}
+
+// delete removes the object stored under the key and returns the status of the deletion.
+func (s *Shard) delete(key string) byte {
+	s.Lock()
+	item := s.load(key)
+	if item == nil {
+		s.Unlock()
+
+		s.stats.DeleteMisses.Add(1)
+		return constants.StatusNotFound
+	}
+
+	s.drop(item)
+	s.Unlock()
+
+	s.stats.DeleteHits.Add(1)
+	return constants.StatusDeleted
+}
 
-	s.slabs[payload.index].freeList.Push(unsafe.Pointer(&payload.payload[0])) //delete our header space
+func (s *Shard) TouchOperationFn(payload Transfer) {
+	id := decoder.RequestID(payload.payload)
 
-	// Fetch and delete the object from the store
-	valueObject, isFound := s.store.Load(key)
+	item, isFound := s.touch(payload)
 	if !isFound {
-		if _, err := payload.conn.Write(constants.ErrObjectNotFound); err != nil {
-			log.Println(err)
+		Respond(payload.conn, id, constants.StatusNotFound, nil)
+		return
+	}
+
+	s.reply(payload.conn, id, constants.StatusTouched, item, nil)
+}
+
+// GetAndTouchOperationFn returns the object and updates its TTL in one request.
+func (s *Shard) GetAndTouchOperationFn(payload Transfer) {
+	id := decoder.RequestID(payload.payload)
+
+	item, isFound := s.touch(payload)
+	if !isFound {
+		Respond(payload.conn, id, constants.StatusNotFound, nil)
+		return
+	}
+
+	s.reply(payload.conn, id, constants.StatusOK, item, item.Value())
+}
+
+// touch sets the TTL of the object to the TTL of the request and refreshes its place in the LRU cache.
+// It frees the chunk of the request and returns the object with a reference held for the caller,
+// or reports false if the object is missing.
+func (s *Shard) touch(payload Transfer) (*Item, bool) {
+	_, keySize, ttl, _ := decoder.Decode(payload.payload)                               // Decode the payload
+	key := string(payload.payload[constants.HeaderSize : constants.HeaderSize+keySize]) // Extract key from the payload
+
+	s.free(payload) //delete our header space
+	s.stats.CmdTouch.Add(1)
+
+	s.Lock()
+	item := s.load(key)
+	if item == nil {
+		s.Unlock()
+
+		s.stats.TouchMisses.Add(1)
+		return nil, false
+	}
+
+	item.expires(TLLParser(ttl))
+	s.schedule(item)
+
+	item.Access()
+	s.policy[item.class].Read(&item.Node)
+	item.acquire()
+	s.Unlock()
+
+	s.stats.TouchHits.Add(1)
+
+	return item, true
+}
+
+func (s *Shard) AppendOperationFn(payload Transfer) {
+	s.concat(payload, false)
+}
+
+func (s *Shard) PrependOperationFn(payload Transfer) {
+	s.concat(payload, true)
+}
+
+// concat adds the body of the request after the stored value, or before it. The object keeps its TTL, flags and
+// metadata. If the grown object no longer fits its chunk, or a response still reads it, it moves to a new chunk,
+// of a larger slab class if needed, and the old chunk is freed.
+func (s *Shard) concat(payload Transfer, prepend bool) {
+	_, keySize, _, bodySize := decoder.Decode(payload.payload) // Decode the payload
+
+	bodyOffset := constants.HeaderSize + keySize
+	key := string(payload.payload[constants.HeaderSize:bodyOffset]) // Extract key from the payload
+	id := decoder.RequestID(payload.payload)
+	data := payload.payload[bodyOffset : bodyOffset+bodySize]
+
+	// The request chunk holds the data until the object is modified
+	defer s.free(payload) //delete our header space
+	s.stats.CmdSet.Add(1)
+
+	for {
+		s.Lock()
+		item := s.load(key)
+		if item == nil {
+			s.Unlock()
+
+			Respond(payload.conn, id, constants.StatusNotStored, nil)
+			return
+		}
+
+		// The grown value still fits the chunk of the object, it is modified in place
+		chunk, value := s.space(item), item.Value()
+		size := len(key) + len(value) + len(data)
+
+		if size <= len(chunk) && !item.isShared() {
+			if prepend {
+				copy(chunk[len(key)+len(data):], value)
+				copy(chunk[len(key):], data)
+			} else {
+				copy(chunk[len(key)+len(value):], data)
+			}
+
+			item.size = uint32(len(value) + len(data))
+			item.cas = s.cas.Add(1)
+			s.policy[item.class].Read(&item.Node)
+			item.acquire()
+			s.Unlock()
+
+			s.reply(payload.conn, id, constants.StatusStored, item, nil)
+			return
+		}
+
+		// GetSlabFor takes the shard lock to evict, so the new chunk is allocated without it
+		cas, flags := item.cas, item.flags
+		s.Unlock()
+
+		grown, index, err := s.GetSlabFor(constants.HeaderSize+size, constants.AppendOperation, []byte(key))
+		if err != nil {
+			Respond(payload.conn, id, StatusOf(err), nil)
+			return
+		}
+
+		s.Lock()
+		item = s.load(key) // The rebalancer may have moved the object to another chunk
+		if item == nil || item.cas != cas {
+			// The object changed in the meantime, start over with the new one
+			s.Unlock()
+			s.free(NewTransfer(grown, index, nil))
+			continue
 		}
+
+		value = item.Value()
+		field := append(append([]byte(nil), value...), data...)
+		if prepend {
+			field = append(append([]byte(nil), data...), value...)
+		}
+
+		n := decoder.EncodeInto(grown, constants.SetOperation, []byte(key), field, 0, flags)
+		item = s.move(item, NewTransfer(grown[:n], index, nil))
+		s.Unlock()
+
+		s.reply(payload.conn, id, constants.StatusStored, item, nil)
 		return
 	}
+}
+
+// move stores the object from the payload in place of the old object, keeping its TTL and metadata.
+// The chunk and LRU node of the old object are freed by the overwrite. It returns the new object
+// with a reference held for the caller. The caller must hold the shard lock.
+func (s *Shard) move(old *Item, payload Transfer) *Item {
+	// The header of the old object is read before the overwrite drops it
+	ttl, access, fetched := old.TTL(), old.access.Load(), old.fetched.Load()
+
+	item := s.insert(payload)
+	item.expires(ttl)
+	item.access.Store(access)
+	item.fetched.Store(fetched)
+	s.schedule(item)
+
+	return item
+}
+
+func (s *Shard) IncrementOperationFn(payload Transfer) {
+	s.counter(payload, false)
+}
+
+func (s *Shard) DecrementOperationFn(payload Transfer) {
+	s.counter(payload, true)
+}
+
+// counter adds the delta from the body to a number stored as a counter, or subtracts it. Incrementing wraps
+// around at 64 bits and decrementing stops at 0, like memcached. The new value is written in place into
+// the object's chunk, or into a new chunk while a response still reads the old value or when the new value
+// outgrows the chunk, and returned as an 8 byte number. If the body holds an initial value, a missing counter
+// is created with it, using the TTL and flags of the request.
+func (s *Shard) counter(payload Transfer, decrement bool) {
+	_, keySize, ttl, bodySize := decoder.Decode(payload.payload) // Decode the payload
+
+	bodyOffset := constants.HeaderSize + keySize
+	key := string(payload.payload[constants.HeaderSize:bodyOffset]) // Extract key from the payload
+	id := decoder.RequestID(payload.payload)
+	flags := decoder.Flags(payload.payload)
 
-	value := valueObject.(Key)
-	s.store.Delete(key)
+	delta, format, initial, create := decoder.DecodeCounter(payload.payload[bodyOffset : bodyOffset+bodySize])
 
-	memoryPointer := value.pointer.GetPointer()
+	s.free(payload) //delete our header space
 
-	s.lru[payload.index].Delete(value.pointer) // Remove from LRU
-	s.slabs[payload.index].freeList.Push(memoryPointer)
-	if _, err := payload.conn.Write(constants.ObjectDeleted); err != nil {
-		log.Println(err)
+	hits, misses := &s.stats.IncrHits, &s.stats.IncrMisses
+	if decrement {
+		hits, misses = &s.stats.DecrHits, &s.stats.DecrMisses
 	}
+
+	for {
+		s.Lock()
+		item := s.load(key)
+		if item == nil && create {
+			// GetSlabFor takes the shard lock to evict, so the counter is allocated without it
+			s.Unlock()
+
+			counter, status := s.allocate([]byte(key), formatCounter(initial, format), ttl, flags)
+			if status != constants.StatusStored {
+				Respond(payload.conn, id, status, nil)
+				return
+			}
+
+			s.Lock()
+			if item = s.load(key); item == nil {
+				item = s.insert(counter)
+				s.Unlock()
+
+				misses.Add(1)
+				s.reply(payload.conn, id, constants.StatusOK, item, formatCounter(initial, constants.CounterBinary))
+				return
+			}
+
+			// Someone else created the counter in the meantime
+			s.free(counter)
+		}
+
+		if item == nil {
+			s.Unlock()
+
+			misses.Add(1)
+			Respond(payload.conn, id, constants.StatusNotFound, nil)
+			return
+		}
+
+		number, ok := parseCounter(item.Value(), format)
+		if !ok {
+			s.Unlock()
+
+			Respond(payload.conn, id, constants.StatusNonNumeric, nil)
+			return
+		}
+
+		if decrement {
+			number -= min(delta, number)
+		} else {
+			number += delta
+		}
+
+		field := formatCounter(number, format)
+
+		chunk := s.space(item)
+		if item.isShared() || len(key)+len(field) > len(chunk) {
+			// A response still reads the value or the new one outgrows the chunk, it goes to a chunk of its own
+			cas, flags := item.cas, item.flags
+			s.Unlock()
+
+			counter, status := s.allocate([]byte(key), field, 0, flags)
+			if status != constants.StatusStored {
+				Respond(payload.conn, id, status, nil)
+				return
+			}
+
+			s.Lock()
+			if item = s.load(key); item == nil || item.cas != cas {
+				// The counter changed in the meantime, start over with the new value
+				s.Unlock()
+				s.free(counter)
+				continue
+			}
+
+			item = s.move(item, counter)
+		} else {
+			copy(chunk[len(key):], field)
+			item.size = uint32(len(field))
+			item.cas = s.cas.Add(1)
+			item.acquire()
+		}
+		s.Unlock()
+
+		hits.Add(1)
+		s.reply(payload.conn, id, constants.StatusOK, item, formatCounter(number, constants.CounterBinary))
+		return
+	}
+}
+
+// parseCounter reads the number stored in a counter, it reports false if the value isn't a number of the format.
+func parseCounter(field []byte, format byte) (uint64, bool) {
+	if format == constants.CounterBinary {
+		if len(field) != 8 {
+			return 0, false
+		}
+
+		return decoder.LittleEndianDecode64(field), true
+	}
+
+	number, err := strconv.ParseUint(string(field), 10, 64)
+	return number, err == nil
+}
+
+// formatCounter returns the value a counter stores for the number.
+func formatCounter(number uint64, format byte) []byte {
+	if format == constants.CounterBinary {
+		field := make([]byte, 8)
+		decoder.LittleEndianEncode64(field, number)
+		return field
+	}
+
+	return strconv.AppendUint(nil, number, 10)
 }
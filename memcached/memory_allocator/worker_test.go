@@ -4,16 +4,19 @@ import (
 	"bytes"
 	"fmt"
 	"log"
+	"sync"
 	"testing"
 
+	"github.com/WatchJani/memCashed/memcached/constants"
+	"github.com/WatchJani/memCashed/memcached/link_list"
 	"github.com/WatchJani/memCashed/memcached/parser"
 )
 
 func Test(t *testing.T) {
-	allocator := New(5 * 1024 * 1024)
+	allocator := newArena(5 * 1024 * 1024)
 
 	slabsSize := []int{
-		64, 128, 256,
+		128, 256,
 		512, 1024, 2048,
 		4096, 8192, 16384,
 		32768, 65536, 131072,
@@ -46,11 +49,711 @@ func Test(t *testing.T) {
 			log.Println(err)
 		}
 
-		slabManager.chooseOperation(NewTransfer(payload[4:], 0, writer))
+		request(t, slabManager, payload, writer)
 	}
 
-	slabManager.store.Range(func(key, value interface{}) bool {
-		fmt.Println(string(value.(Key).field))
+	slabManager.shards[0].index.each(func(item *Item) bool {
+		fmt.Println(string(item.Value()))
 		return true
 	})
 }
+
+func newTestManager() *SlabManager {
+	allocator := newArena(5 * 1024 * 1024)
+
+	slabAllocator := make([]Slab, 3)
+	for i, size := range []int{128, 256, 1024} {
+		slabAllocator[i] = NewSlab(size, 0, allocator)
+	}
+
+	return NewSlabManager(slabAllocator, 1)
+}
+
+// request copies an encoded request into a slab chunk, the same way the server reads it from a connection.
+func request(t *testing.T, sm *SlabManager, payload []byte, writer *bytes.Buffer) {
+	block, index, err := sm.GetSlab(len(payload) - 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	copy(block, payload[4:])
+	sm.shardOf(block).chooseOperation(NewTransfer(block, index, writer))
+}
+
+// stored returns the object stored under the key, without counting a read.
+func stored(sm *SlabManager, key string) (*Item, bool) {
+	shard := sm.shard(key)
+
+	shard.RLock()
+	defer shard.RUnlock()
+
+	item := shard.find(key)
+	if item == nil {
+		return nil, false
+	}
+
+	return item, true
+}
+
+// response reads one response frame from the writer.
+func response(t *testing.T, writer *bytes.Buffer) (byte, []byte) {
+	frame := writer.Next(parser.DecodeLength(writer.Next(4)))
+	if len(frame) < constants.ResponseHeaderSize {
+		t.Fatalf("short response frame: %v", frame)
+	}
+
+	status, _, _, _, body := parser.DecodeResponse(frame)
+	return status, body
+}
+
+func TestResponseFrame(t *testing.T) {
+	sm := newTestManager()
+	writer := &bytes.Buffer{}
+
+	set, _ := parser.Set([]byte("key"), []byte("object not found"), 0)
+	request(t, sm, set, writer)
+
+	if status, _ := response(t, writer); status != constants.StatusStored {
+		t.Errorf("set status: expected %d | get %d", constants.StatusStored, status)
+	}
+
+	get, _ := parser.Get([]byte("key"))
+	request(t, sm, get, writer)
+
+	// a stored value that looks like an error message is still a hit
+	if status, body := response(t, writer); status != constants.StatusOK || string(body) != "object not found" {
+		t.Errorf("get: expected %d %q | get %d %q", constants.StatusOK, "object not found", status, body)
+	}
+
+	missing, _ := parser.Get([]byte("missing"))
+	request(t, sm, missing, writer)
+
+	if status, _ := response(t, writer); status != constants.StatusNotFound {
+		t.Errorf("miss status: expected %d | get %d", constants.StatusNotFound, status)
+	}
+
+	del, _ := parser.Delete([]byte("key"))
+	request(t, sm, del, writer)
+
+	if status, _ := response(t, writer); status != constants.StatusDeleted {
+		t.Errorf("delete status: expected %d | get %d", constants.StatusDeleted, status)
+	}
+}
+
+func TestResponseRequestID(t *testing.T) {
+	sm := newTestManager()
+	writer := &bytes.Buffer{}
+
+	for id := uint32(1); id <= 3; id++ {
+		get, _ := parser.Get([]byte("key"))
+		parser.SetRequestID(get, id)
+		request(t, sm, get, writer)
+
+		frame := writer.Next(parser.DecodeLength(writer.Next(4)))
+		if _, _, get, _, _ := parser.DecodeResponse(frame); get != id {
+			t.Errorf("request id: expected %d | get %d", id, get)
+		}
+	}
+}
+
+func TestMultiGet(t *testing.T) {
+	sm := newTestManager()
+	writer := &bytes.Buffer{}
+
+	set, _ := parser.EncodeFlags(constants.SetOperation, []byte("a"), []byte("first"), -1, 7)
+	request(t, sm, set, writer)
+	response(t, writer)
+
+	body, _ := parser.EncodeKeys([][]byte{[]byte("a"), []byte("missing")})
+	get, _ := parser.Encode(constants.MultiGetOperation, nil, body, 0)
+	request(t, sm, get, writer)
+
+	status, entries := response(t, writer)
+	if status != constants.StatusOK {
+		t.Fatalf("status: expected %d | get %d", constants.StatusOK, status)
+	}
+
+	status, flags, _, value, entries, ok := parser.NextEntry(entries)
+	if !ok || status != constants.StatusOK || flags != 7 || string(value) != "first" {
+		t.Errorf("hit: get status %d, flags %d, value %q", status, flags, value)
+	}
+
+	status, _, _, _, entries, ok = parser.NextEntry(entries)
+	if !ok || status != constants.StatusNotFound || len(entries) != 0 {
+		t.Errorf("miss: get status %d, %d bytes left", status, len(entries))
+	}
+}
+
+func TestMultiSetDelete(t *testing.T) {
+	sm := newTestManager()
+	writer := &bytes.Buffer{}
+
+	// Every item lands in the slab class of its own size.
+	var body []byte
+	body, _ = parser.AppendItem(body, []byte("small"), []byte("v"), 0, 1)
+	body, _ = parser.AppendItem(body, []byte("medium"), bytes.Repeat([]byte("v"), 100), 0, 2)
+	body, _ = parser.AppendItem(body, []byte("large"), bytes.Repeat([]byte("v"), 500), 0, 3)
+
+	set, _ := parser.Encode(constants.MultiSetOperation, nil, body, 0)
+	request(t, sm, set, writer)
+
+	status, statuses := response(t, writer)
+	expected := []byte{constants.StatusStored, constants.StatusStored, constants.StatusStored}
+	if status != constants.StatusOK || !bytes.Equal(statuses, expected) {
+		t.Errorf("set: expected %v | get %d %v", expected, status, statuses)
+	}
+
+	for index, key := range []string{"small", "medium", "large"} {
+		if item, _ := stored(sm, key); int(item.class) != index || item.flags != uint32(index+1) {
+			t.Errorf("%s: stored in slab class %d with flags %d", key, item.class, item.flags)
+		}
+	}
+
+	body, _ = parser.EncodeKeys([][]byte{[]byte("small"), []byte("missing")})
+	del, _ := parser.Encode(constants.MultiDeleteOperation, nil, body, 0)
+	request(t, sm, del, writer)
+
+	status, statuses = response(t, writer)
+	expected = []byte{constants.StatusDeleted, constants.StatusNotFound}
+	if status != constants.StatusOK || !bytes.Equal(statuses, expected) {
+		t.Errorf("delete: expected %v | get %d %v", expected, status, statuses)
+	}
+}
+
+func TestCompareAndSet(t *testing.T) {
+	sm := newTestManager()
+	writer := &bytes.Buffer{}
+
+	set, _ := parser.Set([]byte("key"), []byte("first"), 0)
+	request(t, sm, set, writer)
+	first := sm.cas.Load()
+	response(t, writer)
+
+	for _, step := range []struct {
+		key    string
+		cas    uint64
+		status byte
+	}{
+		{"key", first, constants.StatusStored},
+		{"key", first, constants.StatusExists},
+		{"missing", first, constants.StatusNotFound},
+	} {
+		cas, _ := parser.Encode(constants.CompareAndSetOperation, []byte(step.key), parser.PrependCAS(step.cas, []byte("second")), 0)
+		request(t, sm, cas, writer)
+
+		if status, _ := response(t, writer); status != step.status {
+			t.Errorf("cas %s %d: expected %d | get %d", step.key, step.cas, step.status, status)
+		}
+	}
+
+	get, _ := parser.Get([]byte("key"))
+	request(t, sm, get, writer)
+
+	frame := writer.Next(parser.DecodeLength(writer.Next(4)))
+	if _, _, _, cas, body := parser.DecodeResponse(frame); string(body) != "second" || cas <= first {
+		t.Errorf("get: expected second with a new cas | get %s with cas %d", body, cas)
+	}
+}
+
+func TestConditionalSet(t *testing.T) {
+	sm := newTestManager()
+	writer := &bytes.Buffer{}
+
+	for _, step := range []struct {
+		operation byte
+		value     string
+		status    byte
+	}{
+		{constants.ReplaceOperation, "first", constants.StatusNotStored},
+		{constants.AddOperation, "second", constants.StatusStored},
+		{constants.AddOperation, "third", constants.StatusNotStored},
+		{constants.ReplaceOperation, "fourth", constants.StatusStored},
+	} {
+		payload, _ := parser.Encode(step.operation, []byte("key"), []byte(step.value), 0)
+		request(t, sm, payload, writer)
+
+		if status, _ := response(t, writer); status != step.status {
+			t.Errorf("%c %s: expected %d | get %d", step.operation, step.value, step.status, status)
+		}
+	}
+
+	get, _ := parser.Get([]byte("key"))
+	request(t, sm, get, writer)
+
+	if _, body := response(t, writer); string(body) != "fourth" {
+		t.Errorf("get: expected fourth | get %s", body)
+	}
+}
+
+func TestCounter(t *testing.T) {
+	sm := newTestManager()
+	writer := &bytes.Buffer{}
+
+	for _, step := range []struct {
+		operation byte
+		body      []byte
+		status    byte
+		value     uint64
+	}{
+		{constants.IncrementOperation, parser.EncodeCounter(1, constants.CounterBinary), constants.StatusNotFound, 0},
+		{constants.IncrementOperation, parser.EncodeCounterInitial(1, constants.CounterBinary, 10), constants.StatusOK, 10},
+		{constants.IncrementOperation, parser.EncodeCounterInitial(5, constants.CounterBinary, 10), constants.StatusOK, 15},
+		{constants.DecrementOperation, parser.EncodeCounter(20, constants.CounterBinary), constants.StatusOK, 0},
+		{constants.IncrementOperation, parser.EncodeCounter(1, constants.CounterDecimal), constants.StatusNonNumeric, 0},
+	} {
+		payload, _ := parser.Encode(step.operation, []byte("counter"), step.body, 0)
+		request(t, sm, payload, writer)
+
+		status, body := response(t, writer)
+		if status != step.status || status == constants.StatusOK && parser.LittleEndianDecode64(body) != step.value {
+			t.Errorf("%c %v: expected %d %d | get %d %v", step.operation, step.body, step.status, step.value, status, body)
+		}
+	}
+
+	// The counter is stored in place as an 8 byte number
+	if counter, _ := stored(sm, "counter"); !bytes.Equal(counter.Value(), make([]byte, 8)) {
+		t.Errorf("stored counter: %v", counter.Value())
+	}
+}
+
+func TestCounterMovesSlabClass(t *testing.T) {
+	sm := newTestManager()
+	writer := &bytes.Buffer{}
+
+	// The key and the 19 digits of the counter fill the 128 byte chunk
+	key := bytes.Repeat([]byte("k"), 128-ItemSize-19)
+	set, _ := parser.EncodeFlags(constants.SetOperation, key, []byte("9999999999999999999"), 100, 9)
+	request(t, sm, set, writer)
+	response(t, writer)
+
+	before, _ := stored(sm, string(key))
+	ttl := before.TTL()
+
+	// The 20 digits of the new value outgrow the chunk, the counter moves to the 256 byte class
+	incr, _ := parser.Encode(constants.IncrementOperation, key, parser.EncodeCounter(1, constants.CounterDecimal), 0)
+	request(t, sm, incr, writer)
+
+	if status, body := response(t, writer); status != constants.StatusOK || parser.LittleEndianDecode64(body) != 10000000000000000000 {
+		t.Fatalf("incr: expected %d 10000000000000000000 | get %d %v", constants.StatusOK, status, body)
+	}
+
+	item, _ := stored(sm, string(key))
+
+	if string(item.Value()) != "10000000000000000000" {
+		t.Errorf("value: expected 10000000000000000000 | get %s", item.Value())
+	}
+
+	if item.class != 1 || item.flags != 9 || !item.TTL().Equal(ttl) {
+		t.Errorf("moved counter: class %d, flags %d, ttl %v", item.class, item.flags, item.TTL())
+	}
+}
+
+func TestConcatMovesSlabClass(t *testing.T) {
+	sm := newTestManager()
+	writer := &bytes.Buffer{}
+
+	set, _ := parser.EncodeFlags(constants.SetOperation, []byte("log"), []byte("b"), 100, 9)
+	request(t, sm, set, writer)
+	response(t, writer)
+
+	before, _ := stored(sm, "log")
+	ttl := before.TTL()
+
+	// Fits the 128 byte chunk of the object, it is modified in place
+	prepend, _ := parser.Encode(constants.PrependOperation, []byte("log"), []byte("a"), 0)
+	request(t, sm, prepend, writer)
+	response(t, writer)
+
+	// Outgrows the 128 byte chunk, the object moves to the 256 byte class
+	appended, _ := parser.Encode(constants.AppendOperation, []byte("log"), bytes.Repeat([]byte("c"), 60), 0)
+	request(t, sm, appended, writer)
+
+	if status, _ := response(t, writer); status != constants.StatusStored {
+		t.Fatalf("append: expected %d | get %d", constants.StatusStored, status)
+	}
+
+	item, _ := stored(sm, "log")
+
+	if expected := "ab" + string(bytes.Repeat([]byte("c"), 60)); string(item.Value()) != expected {
+		t.Errorf("value: expected %s | get %s", expected, item.Value())
+	}
+
+	if item.class != 1 || item.flags != 9 || !item.TTL().Equal(ttl) {
+		t.Errorf("moved object: class %d, flags %d, ttl %v", item.class, item.flags, item.TTL())
+	}
+
+	// The old chunk is reused by the next object of its class
+	if block, _, _ := sm.GetSlab(20); itemOf(block) != before {
+		t.Error("old chunk was not freed")
+	}
+
+	if sm.shards[0].policy[0].LastNode() != nil {
+		t.Error("old LRU node was not removed")
+	}
+}
+
+func TestGetAndTouch(t *testing.T) {
+	sm := newTestManager()
+	writer := &bytes.Buffer{}
+
+	set, _ := parser.Set([]byte("session"), []byte("data"), 1)
+	request(t, sm, set, writer)
+	response(t, writer)
+
+	gat, _ := parser.Encode(constants.GetAndTouchOperation, []byte("session"), nil, 100)
+	request(t, sm, gat, writer)
+
+	if status, body := response(t, writer); status != constants.StatusOK || string(body) != "data" {
+		t.Errorf("gat: expected %d data | get %d %s", constants.StatusOK, status, body)
+	}
+
+	if session, _ := stored(sm, "session"); session.TTLRemaining() < 99 {
+		t.Errorf("ttl: expected 100 | get %d", session.TTLRemaining())
+	}
+
+	missing, _ := parser.Encode(constants.GetAndTouchOperation, []byte("missing"), nil, 100)
+	request(t, sm, missing, writer)
+
+	if status, _ := response(t, writer); status != constants.StatusNotFound {
+		t.Errorf("gat miss: expected %d | get %d", constants.StatusNotFound, status)
+	}
+}
+
+func TestPeek(t *testing.T) {
+	// under LFU a single read would keep key-0 from being evicted
+	newPolicy, err := link_list.NewPolicy(constants.PolicyLFU)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sm := NewSlabManagerWithPolicy([]Slab{NewSlab(128, 0, newArena(constants.MiB))}, 1, newPolicy)
+	writer := &bytes.Buffer{}
+
+	// the slab holds one page of objects, the chunk left is for the requests, key-0 is the first to be evicted
+	perPage := constants.MiB / 128
+	for i := range perPage - 1 {
+		set, _ := parser.Set(fmt.Appendf(nil, "key-%d", i), []byte("value"), 100)
+		request(t, sm, set, writer)
+		response(t, writer)
+	}
+
+	peek, _ := parser.Encode(constants.PeekOperation, []byte("key-0"), nil, 0)
+	request(t, sm, peek, writer)
+
+	status, body := response(t, writer)
+	if status != constants.StatusOK {
+		t.Fatalf("peek: expected %d | get %d", constants.StatusOK, status)
+	}
+
+	if ttl, _, fetched, value := parser.DecodeMeta(body); ttl < 99 || fetched || len(value) != 0 {
+		t.Errorf("peek: expected a ttl of 100 without the value | get %d, fetched %t, %d bytes", ttl, fetched, len(value))
+	}
+
+	// a peek is not a read, it counts no get and the object keeps its place in the eviction order
+	if stats := sm.Stats(); stats.CmdGet.Load() != 0 || stats.GetHits.Load() != 0 {
+		t.Errorf("stats: expected no gets | get %d gets, %d hits", stats.CmdGet.Load(), stats.GetHits.Load())
+	}
+
+	// the first object fills the slab, the second evicts
+	for _, key := range []string{"new-0", "new-1"} {
+		set, _ := parser.Set([]byte(key), []byte("value"), 0)
+		request(t, sm, set, writer)
+		response(t, writer)
+	}
+
+	if _, isFound := stored(sm, "key-0"); isFound {
+		t.Error("key-0: expected to be evicted")
+	}
+
+	missing, _ := parser.Encode(constants.PeekOperation, []byte("key-0"), nil, 0)
+	request(t, sm, missing, writer)
+
+	if status, _ := response(t, writer); status != constants.StatusNotFound {
+		t.Errorf("peek miss: expected %d | get %d", constants.StatusNotFound, status)
+	}
+}
+
+func TestOverwriteReleasesOldObject(t *testing.T) {
+	sm := newTestManager()
+	writer := &bytes.Buffer{}
+
+	set := func(value []byte) *Item {
+		payload, _ := parser.Set([]byte("key"), value, 0)
+		request(t, sm, payload, writer)
+		response(t, writer)
+
+		item, _ := stored(sm, "key")
+		return item
+	}
+
+	first := set([]byte("small"))
+	second := set([]byte("small again"))
+
+	// Only the new object is left in the LRU cache of the class
+	if sm.shards[0].policy[0].LastNode() != &second.Node {
+		t.Error("old LRU node was not removed")
+	}
+
+	// The new object is in another class, the old chunk goes back to its own class
+	third := set(bytes.Repeat([]byte("v"), 500))
+	if third.class != 2 || sm.shards[0].policy[0].LastNode() != nil {
+		t.Errorf("object in class %d, class 0 LRU still holds a node", third.class)
+	}
+
+	freed := map[*Item]bool{first: true, second: true}
+	for range freed {
+		if block, _, _ := sm.GetSlab(20); !freed[itemOf(block)] {
+			t.Error("chunk of an overwritten object was not freed")
+		}
+	}
+
+	if items := sm.Stats().CurrItems.Load(); items != 1 {
+		t.Errorf("current items: expected 1 | get %d", items)
+	}
+}
+
+func TestSlabMemoryLimit(t *testing.T) {
+	allocator := newArena(5 * 1024 * 1024)
+
+	slabAllocator := make([]Slab, 3)
+	for i, size := range []int{128, 256, 1024} {
+		slabAllocator[i] = NewSlab(size, 0, allocator)
+	}
+	slabAllocator[2] = NewSlab(1024, 1, allocator) // one page for the largest class
+
+	sm := NewSlabManager(slabAllocator, 1)
+	writer := &bytes.Buffer{}
+
+	chunks := constants.MiB / 1024
+	value := bytes.Repeat([]byte{'v'}, 900)
+	for i := range chunks + 100 {
+		set, _ := parser.Set(fmt.Appendf(nil, "key-%d", i), value, 0)
+		request(t, sm, set, writer)
+
+		if status, _ := response(t, writer); status != constants.StatusStored {
+			t.Fatalf("set %d: expected %d | get %d", i, constants.StatusStored, status)
+		}
+	}
+
+	// the class at its budget evicts from its own LRU instead of taking pages
+	stat := sm.SlabStats()[2]
+	if stat.Pages != 1 || stat.MaxPages != 1 || stat.Evictions != 100 || stat.UsedChunks != chunks {
+		t.Errorf("limited class: expected 1 page, 100 evictions and %d used chunks | get %+v", chunks, stat)
+	}
+
+	if allocator.GetNext() != constants.MiB {
+		t.Errorf("allocator: expected one page handed out | get %d bytes", allocator.GetNext())
+	}
+
+	// the oldest objects were evicted, the newest are still stored
+	get, _ := parser.Get([]byte("key-0"))
+	request(t, sm, get, writer)
+	if status, _ := response(t, writer); status != constants.StatusNotFound {
+		t.Errorf("evicted key: expected %d | get %d", constants.StatusNotFound, status)
+	}
+
+	// classes without a limit still take pages of their own
+	set, _ := parser.Set([]byte("small"), []byte("value"), 0)
+	request(t, sm, set, writer)
+	response(t, writer)
+
+	if stat := sm.SlabStats()[0]; stat.Pages != 1 || stat.MaxPages != 0 {
+		t.Errorf("unlimited class: expected 1 page and no limit | get %+v", stat)
+	}
+}
+
+func TestGetIndex(t *testing.T) {
+	allocator := newArena(constants.MiB)
+
+	slabAllocator := make([]Slab, 4)
+	for i, size := range []int{96, 120, 152, 1000} {
+		slabAllocator[i] = NewSlab(size, 0, allocator)
+	}
+
+	sm := NewSlabManager(slabAllocator, 1)
+	for _, test := range []struct{ size, index, chunk int }{
+		{1, 0, 96}, {96, 0, 96}, {97, 1, 120}, {121, 2, 152}, {152, 2, 152}, {153, 3, 1000}, {1000, 3, 1000}, {1001, 3, 1000},
+	} {
+		if index, chunk := sm.GetIndex(test.size); index != test.index || chunk != test.chunk {
+			t.Errorf("size %d: expected class %d of %d | get %d of %d", test.size, test.index, test.chunk, index, chunk)
+		}
+	}
+
+	// a request larger than the largest chunk doesn't fit anywhere
+	if _, _, err := sm.GetSlab(1001); err != constants.ErrPayloadTooLarge {
+		t.Errorf("too large: expected %v | get %v", constants.ErrPayloadTooLarge, err)
+	}
+}
+
+func TestEvictionPolicies(t *testing.T) {
+	for _, name := range []string{constants.PolicyLRU, constants.PolicyClock, constants.PolicyLFU, constants.PolicyTinyLFU} {
+		newPolicy, err := link_list.NewPolicy(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sm := NewSlabManagerWithPolicy([]Slab{NewSlab(128, 0, newArena(constants.MiB))}, 1, newPolicy)
+		writer := &bytes.Buffer{}
+
+		// the slab holds one page of objects, every object stored after that evicts one
+		perPage := constants.MiB / 128
+		for i := range perPage + 10 {
+			set, _ := parser.Set(fmt.Appendf(nil, "key-%d", i), []byte("value"), 0)
+			request(t, sm, set, writer)
+
+			if status, _ := response(t, writer); status != constants.StatusStored {
+				t.Fatalf("%s set %d: expected %d | get %d", name, i, constants.StatusStored, status)
+			}
+		}
+
+		stat := sm.SlabStats()[0]
+		if stat.Evictions != 10 || stat.Items != perPage || sm.Stats().CurrItems.Load() != int64(perPage) {
+			t.Errorf("%s: expected 10 evictions and %d objects | get %d and %d", name, perPage, stat.Evictions, stat.Items)
+		}
+	}
+}
+
+func TestAdmission(t *testing.T) {
+	sm := NewSlabManager([]Slab{NewSlab(1024, 1, newArena(constants.MiB))}, 1)
+	sm.EnableAdmission()
+	writer := &bytes.Buffer{}
+
+	// every stored object is read once, so each one is estimated more popular than a new key
+	chunks := constants.MiB / 1024
+	for i := range chunks {
+		key := fmt.Appendf(nil, "key-%d", i)
+		set, _ := parser.Set(key, []byte("value"), 0)
+		request(t, sm, set, writer)
+		response(t, writer)
+
+		item, status := sm.shard(string(key)).lookup(string(key))
+		if status != constants.StatusOK {
+			t.Fatalf("get %d: expected %d | get %d", i, constants.StatusOK, status)
+		}
+
+		sm.unref(item)
+	}
+
+	// a key stored once doesn't evict
+	if _, _, err := sm.GetSlabFor(100, constants.SetOperation, []byte("scan")); err != constants.ErrRejected {
+		t.Fatalf("new key: expected %v | get %v", constants.ErrRejected, err)
+	}
+
+	if StatusOf(constants.ErrRejected) != constants.StatusRejected || sm.Stats().Rejected.Load() != 1 || sm.Stats().Evictions.Load() != 0 {
+		t.Errorf("rejected store: expected one rejection and no evictions | get %d and %d", sm.Stats().Rejected.Load(), sm.Stats().Evictions.Load())
+	}
+
+	// requests that don't store an object are not filtered
+	if _, _, err := sm.GetSlabFor(100, constants.GetOperation, []byte("scan")); err != nil {
+		t.Fatalf("get: expected a chunk | get %v", err)
+	}
+
+	// the key stored again is more popular than the victim and evicts it
+	if _, _, err := sm.GetSlabFor(100, constants.SetOperation, []byte("scan")); err != nil {
+		t.Fatalf("popular key: expected a chunk | get %v", err)
+	}
+
+	if sm.Stats().Evictions.Load() != 2 {
+		t.Errorf("evictions: expected 2 | get %d", sm.Stats().Evictions.Load())
+	}
+}
+
+func TestAdmissionParts(t *testing.T) {
+	admission := NewAdmission(4)
+
+	// the top two bits of a hash pick its part, keys of every part are counted at the same time
+	var wg sync.WaitGroup
+	for part := range uint64(4) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for range 3 {
+				admission.Record(part<<62 | 1)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	// a new key is compared with a popular victim of another part
+	if admission.Admit(2, 3<<62|1) {
+		t.Error("new key evicted a key read three times")
+	}
+
+	for range 3 {
+		admission.Record(2)
+	}
+
+	if !admission.Admit(2, 3<<62|1) {
+		t.Error("key read more often than the victim didn't evict it")
+	}
+}
+
+func TestShards(t *testing.T) {
+	allocator := newArena(5 * constants.MiB)
+	slabAllocator := []Slab{NewSlab(128, 0, allocator), NewSlab(1024, 1, allocator)}
+
+	sm := NewShardedSlabManager(slabAllocator, 2, 4, func() link_list.Policy { return link_list.NewSegmented() })
+	writer := &bytes.Buffer{}
+
+	// every shard has a worker, even with fewer workers than shards
+	if sm.Shards() != 4 || sm.Workers() != 4 {
+		t.Errorf("expected 4 shards and 4 workers | get %d and %d", sm.Shards(), sm.Workers())
+	}
+
+	// the class with one page evicts once it is full, every shard from its own LRU
+	chunks := constants.MiB / 1024
+	for i := range chunks + 10 {
+		set, _ := parser.Set(fmt.Appendf(nil, "key-%d", i), bytes.Repeat([]byte{'v'}, 900), 0)
+		request(t, sm, set, writer)
+
+		if status, _ := response(t, writer); status != constants.StatusStored {
+			t.Fatalf("set %d: expected %d | get %d", i, constants.StatusStored, status)
+		}
+	}
+
+	if stat := sm.SlabStats()[1]; stat.Evictions != 10 || stat.Items != chunks || sm.Stats().CurrItems.Load() != int64(chunks) {
+		t.Errorf("expected 10 evictions and %d objects | get %d and %d", chunks, stat.Evictions, stat.Items)
+	}
+
+	// an object is stored only by the shard of its key
+	for i := range 10 {
+		key := fmt.Sprintf("key-%d", chunks+i)
+		for j := range sm.shards {
+			if isFound := sm.shards[j].find(key) != nil; isFound != (&sm.shards[j] == sm.shard(key)) {
+				t.Errorf("%s: found %t in shard %d", key, isFound, j)
+			}
+		}
+	}
+
+	// requests for many keys find every key in its own shard
+	var keys [][]byte
+	for i := range 10 {
+		keys = append(keys, fmt.Appendf(nil, "key-%d", chunks+i))
+	}
+
+	body, _ := parser.EncodeKeys(keys)
+	get, _ := parser.Encode(constants.MultiGetOperation, nil, body, 0)
+	request(t, sm, get, writer)
+
+	_, entries := response(t, writer)
+	for i := range keys {
+		status, _, _, _, rest, ok := parser.NextEntry(entries)
+		if !ok || status != constants.StatusOK {
+			t.Errorf("%s: expected %d | get %d", keys[i], constants.StatusOK, status)
+		}
+
+		entries = rest
+	}
+
+	// requests without a key go to every shard in turn
+	seen := map[*Shard]bool{}
+	for range sm.Shards() {
+		seen[sm.shardOf(get[constants.BufferSizeTCP:])] = true
+	}
+
+	if len(seen) != sm.Shards() {
+		t.Errorf("requests without a key: expected %d shards | get %d", sm.Shards(), len(seen))
+	}
+}
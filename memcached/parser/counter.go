@@ -0,0 +1,48 @@
+package parser
+
+// counter body
+// delta - 8 byte
+// format - 1 byte (optional, decimal by default)
+// initial value - 8 byte (optional, a missing counter is created with it)
+func EncodeCounter(delta uint64, format byte) []byte {
+	body := make([]byte, 9)
+
+	LittleEndianEncode64(body[0:8], delta)
+	body[8] = format
+
+	return body
+}
+
+// EncodeCounterInitial encodes the body of a counter request that creates a missing counter with the initial value.
+func EncodeCounterInitial(delta uint64, format byte, initial uint64) []byte {
+	body := make([]byte, 17)
+
+	LittleEndianEncode64(body[0:8], delta)
+	body[8] = format
+	LittleEndianEncode64(body[9:17], initial)
+
+	return body
+}
+
+// DecodeCounter splits a counter body into delta, format, initial value and whether a missing counter is created.
+func DecodeCounter(body []byte) (uint64, byte, uint64, bool) {
+	var (
+		delta, initial uint64
+		format         byte
+	)
+
+	if len(body) >= 8 {
+		delta = LittleEndianDecode64(body[0:8])
+	}
+
+	if len(body) >= 9 {
+		format = body[8]
+	}
+
+	if len(body) < 17 {
+		return delta, format, 0, false
+	}
+
+	initial = LittleEndianDecode64(body[9:17])
+	return delta, format, initial, true
+}
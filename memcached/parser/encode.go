@@ -19,13 +19,25 @@ func Delete(key []byte) ([]byte, error) {
 }
 
 func Encode(operation byte, key, value []byte, ttl int) ([]byte, error) {
-	payloadSize := uint32(len(value) + len(key) + 10)
+	return EncodeFlags(operation, key, value, ttl, 0)
+}
+
+// EncodeFlags encodes a request that carries client flags, which are stored with the object.
+func EncodeFlags(operation byte, key, value []byte, ttl int, flags uint32) ([]byte, error) {
+	payloadSize := uint32(len(value) + len(key) + 18)
 
 	buf := make([]byte, payloadSize+4)
-	offset := 0
 
-	// payloadSize := uint32(len(value) + len(key) + 10)
-	offset += LittleEndianEncode(buf[offset:offset+4], payloadSize)
+	LittleEndianEncode(buf[0:4], payloadSize)
+	EncodeInto(buf[4:], operation, key, value, ttl, flags)
+
+	return buf, nil
+}
+
+// EncodeInto writes a request without the length prefix into buf and returns the number of bytes written.
+// buf has to hold at least 18 bytes more than the key and the value.
+func EncodeInto(buf []byte, operation byte, key, value []byte, ttl int, flags uint32) int {
+	offset := 0
 
 	buf[offset] = operation
 	offset++
@@ -42,9 +54,35 @@ func Encode(operation byte, key, value []byte, ttl int) ([]byte, error) {
 	bodyLength := uint32(len(value))
 	offset += LittleEndianEncode(buf[offset:offset+4], bodyLength)
 
+	//request id, stamped by the connection that sends the request
+	offset += LittleEndianEncode(buf[offset:offset+4], 0)
+
+	//client flags
+	offset += LittleEndianEncode(buf[offset:offset+4], flags)
+
 	offset += copy(buf[offset:], key)
 
 	offset += copy(buf[offset:], value)
 
-	return buf, nil
+	return offset
+}
+
+// PrependCAS returns the body of a compare and set request: the CAS value followed by the value.
+func PrependCAS(cas uint64, value []byte) []byte {
+	body := make([]byte, 8+len(value))
+
+	LittleEndianEncode64(body[0:8], cas)
+	copy(body[8:], value)
+
+	return body
+}
+
+// SetBodyLength rewrites the body length in the request header (without the length prefix).
+func SetBodyLength(payload []byte, bodyLength uint32) {
+	LittleEndianEncode(payload[6:10], bodyLength)
+}
+
+// SetRequestID stamps the opaque request id into an encoded request (including the length prefix).
+func SetRequestID(request []byte, id uint32) {
+	LittleEndianEncode(request[14:18], id)
 }
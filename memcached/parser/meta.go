@@ -0,0 +1,21 @@
+package parser
+
+// meta get body
+// ttl remaining - 4 byte (0xFFFFFFFF if the object never expires)
+// seconds since last access - 4 byte
+// hit before - 1 byte
+// value
+func EncodeMeta(body []byte, ttl, lastAccess int64, fetched bool) {
+	LittleEndianEncode(body[0:4], uint32(ttl))
+	LittleEndianEncode(body[4:8], uint32(lastAccess))
+
+	body[8] = 0
+	if fetched {
+		body[8] = 1
+	}
+}
+
+// DecodeMeta splits a meta get body into ttl remaining, seconds since last access, hit before and value.
+func DecodeMeta(body []byte) (int64, int64, bool, []byte) {
+	return int64(int32(LittleEndianDecode(body[0:4]))), int64(LittleEndianDecode(body[4:8])), body[8] == 1, body[9:]
+}
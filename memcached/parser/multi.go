@@ -0,0 +1,108 @@
+package parser
+
+import "errors"
+
+var ErrKeyTooLong = errors.New("key is longer than 255 bytes")
+
+// multi key body
+// key length - 1 byte
+// key
+// ... repeated for every key
+func EncodeKeys(keys [][]byte) ([]byte, error) {
+	size := len(keys)
+	for _, key := range keys {
+		if len(key) > 255 {
+			return nil, ErrKeyTooLong
+		}
+
+		size += len(key)
+	}
+
+	buf := make([]byte, 0, size)
+	for _, key := range keys {
+		buf = append(buf, uint8(len(key)))
+		buf = append(buf, key...)
+	}
+
+	return buf, nil
+}
+
+// NextKey splits the first key off a multi key body, it reports false if the body is malformed.
+func NextKey(body []byte) ([]byte, []byte, bool) {
+	if len(body) < 1 || len(body) < 1+int(body[0]) {
+		return nil, nil, false
+	}
+
+	return body[1 : 1+body[0]], body[1+body[0]:], true
+}
+
+// multi set body
+// key length - 1 byte
+// ttl - 4 byte
+// flags - 4 byte
+// value length - 4 byte
+// key
+// value
+// ... repeated for every item
+func AppendItem(buf []byte, key, value []byte, ttl int, flags uint32) ([]byte, error) {
+	if len(key) > 255 {
+		return nil, ErrKeyTooLong
+	}
+
+	header := make([]byte, 13)
+
+	header[0] = uint8(len(key))
+	LittleEndianEncode(header[1:5], uint32(ttl))
+	LittleEndianEncode(header[5:9], flags)
+	LittleEndianEncode(header[9:13], uint32(len(value)))
+
+	return append(append(append(buf, header...), key...), value...), nil
+}
+
+// NextItem splits the first item off a multi set body into key, value, ttl, flags and the rest of the body.
+// It reports false if the body is malformed.
+func NextItem(body []byte) ([]byte, []byte, uint32, uint32, []byte, bool) {
+	if len(body) < 13 {
+		return nil, nil, 0, 0, nil, false
+	}
+
+	keyEnd := 13 + int(body[0])
+	end := keyEnd + int(LittleEndianDecode(body[9:13]))
+	if len(body) < end {
+		return nil, nil, 0, 0, nil, false
+	}
+
+	return body[13:keyEnd], body[keyEnd:end], LittleEndianDecode(body[1:5]), LittleEndianDecode(body[5:9]), body[end:], true
+}
+
+// multi get entry
+// status - 1 byte
+// flags - 4 byte
+// cas - 8 byte
+// value length - 4 byte
+// value
+func AppendEntry(buf []byte, status byte, flags uint32, cas uint64, value []byte) []byte {
+	header := make([]byte, 17)
+
+	header[0] = status
+	LittleEndianEncode(header[1:5], flags)
+	LittleEndianEncode64(header[5:13], cas)
+	LittleEndianEncode(header[13:17], uint32(len(value)))
+
+	return append(append(buf, header...), value...)
+}
+
+// NextEntry splits the first entry off a multi get body into status, flags, cas, value and the rest of the body.
+// It reports false if the body is malformed.
+func NextEntry(body []byte) (byte, uint32, uint64, []byte, []byte, bool) {
+	if len(body) < 17 {
+		return 0, 0, 0, nil, nil, false
+	}
+
+	end := 17 + int(LittleEndianDecode(body[13:17]))
+	if len(body) < end {
+		return 0, 0, 0, nil, nil, false
+	}
+
+	return body[0], LittleEndianDecode(body[1:5]), LittleEndianDecode64(body[5:13]), body[17:end], body[end:], true
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"github.com/WatchJani/memCashed/memcached/constants"
+	"github.com/WatchJani/memCashed/memcached/memory_allocator"
+	decoder "github.com/WatchJani/memCashed/memcached/parser"
+)
+
+// Result is the decoded response to a request submitted by a protocol front end.
+type Result struct {
+	Status byte   // Status code of the operation.
+	Flags  uint32 // Client flags stored with the object.
+	CAS    uint64 // CAS value of the object.
+	Value  []byte // Response body.
+}
+
+// Reply receives the response frame of a request submitted by a protocol front end.
+// Workers write to it like they write to a binary protocol connection.
+type Reply chan []byte
+
+// Write receives a response frame from a worker.
+func (r Reply) Write(frame []byte) (int, error) {
+	r <- append([]byte(nil), frame...)
+	return len(frame), nil
+}
+
+// Result waits for the response frame and decodes it.
+func (r Reply) Result() Result {
+	frame := <-r
+	status, flags, _, cas, body := decoder.DecodeResponse(frame[constants.BufferSizeTCP:])
+
+	return Result{
+		Status: status,
+		Flags:  flags,
+		CAS:    cas,
+		Value:  body,
+	}
+}
+
+// Submit encodes a request the way the binary protocol does, copies it into a slab chunk
+// and hands it to the workers. Front ends for other protocols use it, so every protocol
+// shares the same slab storage and LRU.
+func (s *Server) Submit(operation byte, key, value []byte, ttl int, flags uint32) Reply {
+	reply := make(Reply, 1)
+
+	request, err := decoder.EncodeFlags(operation, key, value, ttl, flags)
+	if err != nil {
+		reply.Write(decoder.EncodeResponse(constants.StatusUnsupported, 0, 0, 0, nil))
+		return reply
+	}
+
+	payload := request[constants.BufferSizeTCP:]
+
+	// Get a slab block and its index from the memory allocator.
+	slabBlock, index, err := s.Manager.GetSlabFor(len(payload), operation, key)
+	if err != nil {
+		reply.Write(decoder.EncodeResponse(memory_allocator.StatusOf(err), 0, 0, 0, nil))
+		return reply
+	}
+
+	copy(slabBlock, payload)
+	s.Req(slabBlock, index, reply)
+
+	return reply
+}
+
+// Exec submits a request and waits for its result.
+func (s *Server) Exec(operation byte, key, value []byte, ttl int, flags uint32) Result {
+	return s.Submit(operation, key, value, ttl, flags).Result()
+}
+
+// GetMulti looks up every key with a single multi get request and returns one result per key,
+// in the order of the keys. If the request itself fails, every result carries its status.
+func (s *Server) GetMulti(keys [][]byte) []Result {
+	results := make([]Result, len(keys))
+
+	body, err := decoder.EncodeKeys(keys)
+	if err != nil {
+		return fill(results, constants.StatusUnsupported)
+	}
+
+	result := s.Exec(constants.MultiGetOperation, nil, body, 0, 0)
+	if result.Status != constants.StatusOK {
+		return fill(results, result.Status)
+	}
+
+	entries := result.Value
+	for i := range results {
+		status, flags, cas, value, rest, ok := decoder.NextEntry(entries)
+		if !ok {
+			fill(results[i:], constants.StatusUnsupported)
+			break
+		}
+
+		results[i] = Result{Status: status, Flags: flags, CAS: cas, Value: value}
+		entries = rest
+	}
+
+	return results
+}
+
+// fill sets the status of every result.
+func fill(results []Result, status byte) []Result {
+	for i := range results {
+		results[i].Status = status
+	}
+
+	return results
+}
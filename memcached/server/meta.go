@@ -0,0 +1,500 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/WatchJani/memCashed/memcached/constants"
+	decoder "github.com/WatchJani/memCashed/memcached/parser"
+)
+
+// Return codes of the memcached meta protocol.
+const (
+	MetaHit       = "HD"
+	MetaValue     = "VA"
+	MetaMiss      = "EN"
+	MetaNotFound  = "NF"
+	MetaNotStored = "NS"
+	MetaExists    = "EX"
+	MetaNoOp      = "MN\r\n"
+
+	TextInvalidFlag = "CLIENT_ERROR invalid flag\r\n"
+	TextInvalidMode = "CLIENT_ERROR invalid mode\r\n"
+	TextBadKey      = "CLIENT_ERROR error decoding key\r\n"
+)
+
+// Flags accepted by each meta command, and the subset reported back in the reply.
+const (
+	MetaGetFlags        = "bcfhklOqstvNT"
+	MetaGetReturn       = "bcfhklOst"
+	MetaSetFlags        = "bcCFkOqTM"
+	MetaSetReturn       = "bckO"
+	MetaDeleteFlags     = "bkOq"
+	MetaCodeReturn      = "bkO"
+	MetaArithmeticFlags = "bcktOqvNJDTM"
+	MetaArithmeticRet   = "bcktO"
+)
+
+var (
+	errInvalidFlag = errors.New("invalid flag")
+	errBadKey      = errors.New("bad key")
+)
+
+// MetaCommand is a parsed meta command: its key and flags.
+type MetaCommand struct {
+	Key    []byte          // Key, decoded if it was sent in base64.
+	RawKey []byte          // Key as it was sent.
+	Tokens map[byte][]byte // Token of every flag, by flag character.
+	Order  []byte          // Flags in the order they were sent, the reply reports them in that order.
+}
+
+// MetaInfo is what a meta reply can report about an object.
+type MetaInfo struct {
+	CAS        uint64 // CAS value of the object.
+	Flags      uint32 // Client flags of the object.
+	TTL        int64  // Seconds until the object expires, -1 if it never does.
+	LastAccess int64  // Seconds since the object was last accessed.
+	Fetched    bool   // Whether the object was read before.
+	Size       int    // Size of the value.
+	Won        bool   // The object was created by this request (vivify on miss).
+}
+
+// ParseMeta parses the key and the flags of a meta command, accepting only the given flags.
+func ParseMeta(key []byte, flags [][]byte, accepted string) (MetaCommand, error) {
+	m := MetaCommand{
+		Key:    key,
+		RawKey: key,
+		Tokens: make(map[byte][]byte, len(flags)),
+	}
+
+	for _, flag := range flags {
+		if len(flag) == 0 || !bytes.ContainsRune([]byte(accepted), rune(flag[0])) {
+			return m, errInvalidFlag
+		}
+
+		m.Tokens[flag[0]] = flag[1:]
+		m.Order = append(m.Order, flag[0])
+	}
+
+	if m.Has('b') {
+		decoded, err := base64.StdEncoding.DecodeString(string(key))
+		if err != nil {
+			return m, err
+		}
+
+		m.Key = decoded
+	}
+
+	if !IsKey(m.Key) {
+		return m, errBadKey
+	}
+
+	return m, nil
+}
+
+// Has checks if the flag was sent.
+func (m MetaCommand) Has(flag byte) bool {
+	_, ok := m.Tokens[flag]
+	return ok
+}
+
+// Number returns the numeric token of the flag, or the default value if the flag wasn't sent.
+func (m MetaCommand) Number(flag byte, defaultValue int64) (int64, error) {
+	token, ok := m.Tokens[flag]
+	if !ok {
+		return defaultValue, nil
+	}
+
+	return strconv.ParseInt(string(token), 10, 64)
+}
+
+// ReturnFlags formats the flags reported in the reply, in the order the client sent them.
+func (m MetaCommand) ReturnFlags(info MetaInfo, reported string) string {
+	var flags bytes.Buffer
+
+	for _, flag := range m.Order {
+		if !bytes.ContainsRune([]byte(reported), rune(flag)) {
+			continue
+		}
+
+		switch flag {
+		case 'b':
+			if m.Has('k') {
+				flags.WriteString(" b")
+			}
+		case 'c':
+			fmt.Fprintf(&flags, " c%d", info.CAS)
+		case 'f':
+			fmt.Fprintf(&flags, " f%d", info.Flags)
+		case 'h':
+			if info.Fetched {
+				flags.WriteString(" h1")
+			} else {
+				flags.WriteString(" h0")
+			}
+		case 'k':
+			fmt.Fprintf(&flags, " k%s", m.RawKey)
+		case 'l':
+			fmt.Fprintf(&flags, " l%d", info.LastAccess)
+		case 'O':
+			fmt.Fprintf(&flags, " O%s", m.Tokens['O'])
+		case 's':
+			fmt.Fprintf(&flags, " s%d", info.Size)
+		case 't':
+			fmt.Fprintf(&flags, " t%d", info.TTL)
+		}
+	}
+
+	if info.Won {
+		flags.WriteString(" W")
+	}
+
+	return flags.String()
+}
+
+// ReplyMeta writes a meta reply: the return code, the reported flags and, with the v flag, the value.
+func (c *TextConn) ReplyMeta(m MetaCommand, info MetaInfo, reported string, value []byte) error {
+	flags := m.ReturnFlags(info, reported)
+
+	if m.Has('v') {
+		fmt.Fprintf(c, "%s %d%s\r\n", MetaValue, len(value), flags)
+		c.Write(value)
+		return c.Reply("\r\n")
+	}
+
+	return c.Reply(MetaHit + flags + "\r\n")
+}
+
+// ReplyMetaCode writes a return code that carries only the opaque and key flags.
+func (c *TextConn) ReplyMetaCode(m MetaCommand, code string) error {
+	return c.Reply(code + m.ReturnFlags(MetaInfo{}, MetaCodeReturn) + "\r\n")
+}
+
+// ReplyMetaError writes the reply for an error status of a meta command.
+func (c *TextConn) ReplyMetaError(m MetaCommand, status byte) error {
+	switch status {
+	case constants.StatusNotFound, constants.StatusExpired:
+		if m.Has('q') {
+			return nil
+		}
+
+		return c.ReplyMetaCode(m, MetaNotFound)
+	case constants.StatusNotStored:
+		return c.ReplyMetaCode(m, MetaNotStored)
+	case constants.StatusExists:
+		return c.ReplyMetaCode(m, MetaExists)
+	default:
+		return c.ReplyStatus(status, false)
+	}
+}
+
+// MetaGetInfo runs a meta get request and decodes the metadata of the object.
+func (s *Server) MetaGetInfo(key []byte) (MetaInfo, []byte, byte) {
+	result := s.Exec(constants.MetaGetOperation, key, nil, 0, 0)
+	if result.Status != constants.StatusOK {
+		return MetaInfo{}, nil, result.Status
+	}
+
+	ttl, lastAccess, fetched, value := decoder.DecodeMeta(result.Value)
+	return MetaInfo{
+		CAS:        result.CAS,
+		Flags:      result.Flags,
+		TTL:        ttl,
+		LastAccess: lastAccess,
+		Fetched:    fetched,
+		Size:       len(value),
+	}, value, constants.StatusOK
+}
+
+// PeekInfo looks up the metadata of an object without counting a read or refreshing its place in the
+// eviction order. The size of the value is not reported.
+func (s *Server) PeekInfo(key []byte) (MetaInfo, byte) {
+	result := s.Exec(constants.PeekOperation, key, nil, 0, 0)
+	if result.Status != constants.StatusOK {
+		return MetaInfo{}, result.Status
+	}
+
+	ttl, lastAccess, fetched, _ := decoder.DecodeMeta(result.Value)
+	return MetaInfo{
+		CAS:        result.CAS,
+		Flags:      result.Flags,
+		TTL:        ttl,
+		LastAccess: lastAccess,
+		Fetched:    fetched,
+	}, constants.StatusOK
+}
+
+// MetaGet handles mg <key> <flags>*
+func (c *TextConn) MetaGet(args [][]byte) error {
+	if len(args) < 1 {
+		return c.Reply(TextBadFormat)
+	}
+
+	m, err := ParseMeta(args[0], args[1:], MetaGetFlags)
+	if err != nil {
+		return c.Reply(MetaParseError(err))
+	}
+
+	vivify, errVivify := m.Number('N', 0)
+	exptime, errExptime := m.Number('T', 0)
+	if errVivify != nil || errExptime != nil {
+		return c.Reply(TextInvalidFlag)
+	}
+
+	// With the T flag the object gets a new TTL before it is read.
+	ttl, alive := Expiration(exptime)
+	if m.Has('T') && alive {
+		c.server.Exec(constants.TouchOperation, m.Key, nil, ttl, 0)
+	}
+
+	info, value, status := c.server.MetaGetInfo(m.Key)
+	if status == constants.StatusOK {
+		if m.Has('T') && !alive {
+			c.server.Exec(constants.DeleteOperation, m.Key, nil, 0, 0) // expires right after it is read
+		}
+
+		return c.ReplyMeta(m, info, MetaGetReturn, value)
+	}
+
+	if !m.Has('N') {
+		if m.Has('q') {
+			return nil
+		}
+
+		return c.ReplyMetaCode(m, MetaMiss)
+	}
+
+	// Vivify on miss: create an empty object, the client that created it is told it won the recache.
+	ttl, _ = Expiration(vivify)
+	created := c.server.Exec(constants.AddOperation, m.Key, nil, ttl, 0)
+	if created.Status == constants.StatusStored {
+		return c.ReplyMeta(m, MetaInfo{CAS: created.CAS, TTL: TTLOf(ttl), Won: true}, MetaGetReturn, nil)
+	}
+
+	// Someone else created it first.
+	info, value, status = c.server.MetaGetInfo(m.Key)
+	if status != constants.StatusOK {
+		return c.ReplyMetaError(m, status)
+	}
+
+	return c.ReplyMeta(m, info, MetaGetReturn, value)
+}
+
+// TTLOf returns the TTL reported for an object stored with the TTL in seconds.
+func TTLOf(ttl int) int64 {
+	if ttl == 0 {
+		return -1
+	}
+
+	return int64(ttl)
+}
+
+// MetaSet handles ms <key> <datalen> <flags>*
+func (c *TextConn) MetaSet(args [][]byte) error {
+	if len(args) < 2 {
+		return c.Reply(TextBadFormat)
+	}
+
+	size, err := strconv.Atoi(string(args[1]))
+	if err != nil || size < 0 {
+		return c.Reply(TextBadFormat)
+	}
+
+	// Read the data block and the \r\n that ends it, before anything can be refused.
+	value, err := c.ReadData(size)
+	if err != nil || value == nil {
+		return err
+	}
+
+	m, err := ParseMeta(args[0], args[2:], MetaSetFlags)
+	if err != nil {
+		return c.Reply(MetaParseError(err))
+	}
+
+	flags, errFlags := m.Number('F', 0)
+	exptime, errExptime := m.Number('T', 0)
+	if errFlags != nil || errExptime != nil {
+		return c.Reply(TextInvalidFlag)
+	}
+
+	operation, ok := MetaSetMode(m.Tokens['M'])
+	if !ok {
+		return c.Reply(TextInvalidMode)
+	}
+
+	// With a CAS value the set only stores if the object wasn't modified since.
+	if m.Has('C') && operation == constants.SetOperation {
+		unique, err := strconv.ParseUint(string(m.Tokens['C']), 10, 64)
+		if err != nil {
+			return c.Reply(TextInvalidFlag)
+		}
+
+		operation, value = constants.CompareAndSetOperation, decoder.PrependCAS(unique, value)
+	}
+
+	result := Result{Status: constants.StatusStored}
+
+	if IsConcat(operation) {
+		exptime = 0 // The object keeps its expiration time
+	}
+
+	ttl, alive := Expiration(exptime)
+	if alive {
+		result = c.server.Exec(operation, m.Key, value, ttl, uint32(flags))
+	} else {
+		result.Status = c.StoreExpired(operation, m.Key, value)
+	}
+
+	if result.Status != constants.StatusStored {
+		return c.ReplyMetaError(m, result.Status)
+	}
+
+	if m.Has('q') {
+		return nil
+	}
+
+	return c.Reply(MetaHit + m.ReturnFlags(MetaInfo{CAS: result.CAS}, MetaSetReturn) + "\r\n")
+}
+
+// MetaSetMode returns the operation of a meta set mode, set by default.
+func MetaSetMode(mode []byte) (byte, bool) {
+	if len(mode) == 0 {
+		return constants.SetOperation, true
+	}
+
+	switch mode[0] {
+	case 'S', 's':
+		return constants.SetOperation, true
+	case 'E', 'e':
+		return constants.AddOperation, true
+	case 'R', 'r':
+		return constants.ReplaceOperation, true
+	case 'A', 'a':
+		return constants.AppendOperation, true
+	case 'P', 'p':
+		return constants.PrependOperation, true
+	default:
+		return 0, false
+	}
+}
+
+// MetaDelete handles md <key> <flags>*
+func (c *TextConn) MetaDelete(args [][]byte) error {
+	if len(args) < 1 {
+		return c.Reply(TextBadFormat)
+	}
+
+	m, err := ParseMeta(args[0], args[1:], MetaDeleteFlags)
+	if err != nil {
+		return c.Reply(MetaParseError(err))
+	}
+
+	result := c.server.Exec(constants.DeleteOperation, m.Key, nil, 0, 0)
+	if result.Status != constants.StatusDeleted {
+		return c.ReplyMetaError(m, result.Status)
+	}
+
+	if m.Has('q') {
+		return nil
+	}
+
+	return c.ReplyMetaCode(m, MetaHit)
+}
+
+// MetaArithmetic handles ma <key> <flags>*
+func (c *TextConn) MetaArithmetic(args [][]byte) error {
+	if len(args) < 1 {
+		return c.Reply(TextBadFormat)
+	}
+
+	m, err := ParseMeta(args[0], args[1:], MetaArithmeticFlags)
+	if err != nil {
+		return c.Reply(MetaParseError(err))
+	}
+
+	vivify, errVivify := m.Number('N', 0)
+	initial, errInitial := m.Number('J', 0)
+	delta, errDelta := m.Number('D', 1)
+	exptime, errExptime := m.Number('T', 0)
+	if errVivify != nil || errInitial != nil || errDelta != nil || errExptime != nil || initial < 0 || delta < 0 {
+		return c.Reply(TextInvalidFlag)
+	}
+
+	operation, ok := MetaArithmeticMode(m.Tokens['M'])
+	if !ok {
+		return c.Reply(TextInvalidMode)
+	}
+
+	body, ttl := decoder.EncodeCounter(uint64(delta), constants.CounterDecimal), 0
+
+	// On a miss the server creates the counter with its initial value.
+	if m.Has('N') {
+		body = decoder.EncodeCounterInitial(uint64(delta), constants.CounterDecimal, uint64(initial))
+		ttl, _ = Expiration(vivify)
+	}
+
+	result := c.server.Exec(operation, m.Key, body, ttl, 0)
+	number := Counter(result)
+
+	if result.Status != constants.StatusOK {
+		return c.ReplyMetaError(m, result.Status)
+	}
+
+	if m.Has('T') {
+		ttl, _ := Expiration(exptime)
+		c.server.Exec(constants.TouchOperation, m.Key, nil, ttl, 0)
+	}
+
+	info := MetaInfo{CAS: result.CAS, TTL: -1}
+	if m.Has('t') {
+		if current, _, status := c.server.MetaGetInfo(m.Key); status == constants.StatusOK {
+			info.TTL = current.TTL
+		}
+	}
+
+	if m.Has('q') && !m.Has('v') {
+		return nil
+	}
+
+	return c.ReplyMeta(m, info, MetaArithmeticRet, strconv.AppendUint(nil, number, 10))
+}
+
+// MetaArithmeticMode returns the operation of a meta arithmetic mode, increment by default.
+func MetaArithmeticMode(mode []byte) (byte, bool) {
+	if len(mode) == 0 {
+		return constants.IncrementOperation, true
+	}
+
+	switch mode[0] {
+	case 'I', 'i', '+':
+		return constants.IncrementOperation, true
+	case 'D', 'd', '-':
+		return constants.DecrementOperation, true
+	default:
+		return 0, false
+	}
+}
+
+// MetaParseError returns the reply for an error parsing a meta command.
+func MetaParseError(err error) string {
+	switch err {
+	case errInvalidFlag:
+		return TextInvalidFlag
+	case errBadKey:
+		return TextBadFormat
+	default:
+		return TextBadKey
+	}
+}
+
+// Counter returns the value of a counter from the result of an increment request.
+func Counter(result Result) uint64 {
+	if len(result.Value) < 8 {
+		return 0
+	}
+
+	return decoder.LittleEndianDecode64(result.Value)
+}
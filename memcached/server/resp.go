@@ -0,0 +1,543 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/WatchJani/memCashed/memcached/constants"
+)
+
+// Replies of the Redis serialization protocol.
+const (
+	RESPOK          = "+OK\r\n"
+	RESPPong        = "+PONG\r\n"
+	RESPNull2       = "$-1\r\n" // Null bulk string of RESP2
+	RESPNull3       = "_\r\n"   // Null of RESP3
+	RESPSyntax      = "-ERR syntax error\r\n"
+	RESPNotInteger  = "-ERR value is not an integer or out of range\r\n"
+	RESPKeyTooLong  = "-ERR key is too long\r\n"
+	RESPTooLarge    = "-ERR value is too large\r\n"
+	RESPOutOfMemory = "-OOM command not allowed when used memory > 'maxmemory'.\r\n"
+	RESPRejected    = "-ERR value rejected by the admission filter\r\n"
+	RESPUnsupported = "-NOPROTO unsupported protocol version\r\n"
+
+	MaxBulkLength = 64 * constants.MiB // Longest bulk string read from a client
+	MaxArguments  = 1024 * 1024        // Most arguments in a single command
+)
+
+var errRESPProtocol = errors.New("resp protocol error")
+
+// RESPConn is a connection speaking the Redis serialization protocol.
+type RESPConn struct {
+	*bufio.Reader // Buffered reader of the connection.
+	*bufio.Writer // Buffered writer of the connection.
+	server        *Server
+	version       int // Protocol version negotiated with HELLO, 2 by default.
+}
+
+// HandleRESPConn processes a connection speaking RESP2 or RESP3. The string commands are
+// translated into the same requests the binary protocol sends to the workers, so Redis clients
+// share the slab storage and LRU with every other protocol.
+func (s *Server) HandleRESPConn(conn net.Conn) {
+	// Ensure the connection is closed and the active connection count is reduced when done.
+	defer func() {
+		Close(conn, constants.InfoConnectionClose)
+		s.decrease()
+	}()
+
+	c := RESPConn{
+		Reader:  bufio.NewReader(conn),
+		Writer:  bufio.NewWriter(conn),
+		server:  s,
+		version: 2,
+	}
+
+	for {
+		args, err := c.ReadCommand()
+		if err != nil {
+			// If an error occurs during reading (excluding EOF), log it.
+			if err != io.EOF {
+				log.Println(err)
+			}
+
+			break // Exit the loop if reading fails.
+		}
+
+		if err := c.Command(args); err != nil {
+			if err != errQuit {
+				log.Println(err)
+			}
+
+			c.Flush()
+			break
+		}
+
+		// Send the replies once there are no more pipelined commands to run.
+		if c.Reader.Buffered() == 0 {
+			if err := c.Flush(); err != nil {
+				log.Println(err)
+				break
+			}
+		}
+	}
+}
+
+// ReadCommand reads a command sent as an array of bulk strings, or as an inline command.
+func (c *RESPConn) ReadCommand() ([][]byte, error) {
+	line, err := c.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) == 0 || line[0] != '*' {
+		return bytes.Fields(line), nil // Inline command, as sent from telnet
+	}
+
+	count, err := strconv.Atoi(string(line[1:]))
+	if err != nil || count > MaxArguments {
+		return nil, errRESPProtocol
+	}
+
+	args := make([][]byte, 0, max(count, 0))
+	for range count {
+		header, err := c.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(header) == 0 || header[0] != '$' {
+			return nil, errRESPProtocol
+		}
+
+		size, err := strconv.Atoi(string(header[1:]))
+		if err != nil || size < 0 || size > MaxBulkLength {
+			return nil, errRESPProtocol
+		}
+
+		// Read the bulk string and the \r\n that ends it.
+		bulk := make([]byte, size+2)
+		if _, err := io.ReadFull(c.Reader, bulk); err != nil {
+			return nil, err
+		}
+
+		args = append(args, bulk[:size])
+	}
+
+	return args, nil
+}
+
+// ReadLine reads a line without its \r\n.
+func (c *RESPConn) ReadLine() ([]byte, error) {
+	line, err := c.ReadSlice('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimRight(line, "\r\n"), nil
+}
+
+// Command runs a single command.
+func (c *RESPConn) Command(args [][]byte) error {
+	if len(args) == 0 {
+		return nil
+	}
+
+	name := strings.ToLower(string(args[0]))
+	args = args[1:]
+
+	switch name {
+	case "get":
+		return c.Get(name, args)
+	case "set":
+		return c.Set(name, args)
+	case "del":
+		return c.Del(name, args)
+	case "exists":
+		return c.Exists(name, args)
+	case "expire":
+		return c.Expire(name, args)
+	case "ttl":
+		return c.TTL(name, args)
+	case "mget":
+		return c.MGet(name, args)
+	case "mset":
+		return c.MSet(name, args)
+	case "ping":
+		return c.Ping(name, args)
+	case "hello":
+		return c.Hello(args)
+	case "command":
+		return c.Reply("*0\r\n") // Clients only ask for the command table to enable optional features
+	case "select":
+		return c.Select(name, args)
+	case "quit":
+		c.Reply(RESPOK)
+		return errQuit
+	default:
+		return c.Error(fmt.Sprintf("ERR unknown command '%s'", name))
+	}
+}
+
+// Reply writes a reply to the connection's buffer.
+func (c *RESPConn) Reply(reply string) error {
+	_, err := c.WriteString(reply)
+	return err
+}
+
+// Error writes an error reply.
+func (c *RESPConn) Error(message string) error {
+	return c.Reply("-" + message + "\r\n")
+}
+
+// ArityError writes the reply for a command called with a wrong number of arguments.
+func (c *RESPConn) ArityError(name string) error {
+	return c.Error(fmt.Sprintf("ERR wrong number of arguments for '%s' command", name))
+}
+
+// Integer writes an integer reply.
+func (c *RESPConn) Integer(number int64) error {
+	return c.Reply(":" + strconv.FormatInt(number, 10) + "\r\n")
+}
+
+// Bulk writes a bulk string reply.
+func (c *RESPConn) Bulk(value []byte) error {
+	c.WriteString("$" + strconv.Itoa(len(value)) + "\r\n")
+	c.Write(value)
+	return c.Reply("\r\n")
+}
+
+// Null writes the null reply of the negotiated protocol version.
+func (c *RESPConn) Null() error {
+	if c.version == 3 {
+		return c.Reply(RESPNull3)
+	}
+
+	return c.Reply(RESPNull2)
+}
+
+// Array writes the header of an array reply with the given number of elements.
+func (c *RESPConn) Array(length int) error {
+	return c.Reply("*" + strconv.Itoa(length) + "\r\n")
+}
+
+// ReplyStatus writes the error reply for a status that has no reply of its own.
+func (c *RESPConn) ReplyStatus(status byte) error {
+	switch status {
+	case constants.StatusNotEnoughSpace:
+		return c.Reply(RESPOutOfMemory)
+	case constants.StatusTooLarge:
+		return c.Reply(RESPTooLarge)
+	case constants.StatusRejected:
+		return c.Reply(RESPRejected)
+	default:
+		return c.Error(fmt.Sprintf("ERR unexpected status %d", status))
+	}
+}
+
+// Get handles GET key
+func (c *RESPConn) Get(name string, args [][]byte) error {
+	if len(args) != 1 {
+		return c.ArityError(name)
+	}
+
+	if !IsKey(args[0]) {
+		return c.Null()
+	}
+
+	result := c.server.Exec(constants.GetOperation, args[0], nil, 0, 0)
+	if result.Status != constants.StatusOK {
+		return c.Null()
+	}
+
+	return c.Bulk(result.Value)
+}
+
+// Set handles SET key value [NX | XX] [EX seconds | PX milliseconds]
+// The store has a resolution of seconds, PX is rounded up to the next second.
+func (c *RESPConn) Set(name string, args [][]byte) error {
+	if len(args) < 2 {
+		return c.ArityError(name)
+	}
+
+	operation, ttl := byte(constants.SetOperation), 0
+	hasTTL := false
+
+	for i := 2; i < len(args); i++ {
+		switch option := strings.ToLower(string(args[i])); option {
+		case "nx", "xx":
+			if operation != constants.SetOperation {
+				return c.Reply(RESPSyntax)
+			}
+
+			operation = constants.AddOperation
+			if option == "xx" {
+				operation = constants.ReplaceOperation
+			}
+		case "ex", "px":
+			if hasTTL || i+1 == len(args) {
+				return c.Reply(RESPSyntax)
+			}
+
+			i++
+			number, err := strconv.ParseInt(string(args[i]), 10, 64)
+			if err != nil || number <= 0 {
+				return c.Error(fmt.Sprintf("ERR invalid expire time in '%s' command", name))
+			}
+
+			if option == "px" {
+				number = (number + 999) / 1000
+			}
+
+			// The TTL of a request is a number of seconds in 32 bits
+			if number > math.MaxUint32 {
+				return c.Error(fmt.Sprintf("ERR invalid expire time in '%s' command", name))
+			}
+
+			ttl, hasTTL = int(number), true
+		default:
+			return c.Reply(RESPSyntax)
+		}
+	}
+
+	if !IsKey(args[0]) {
+		return c.Reply(RESPKeyTooLong)
+	}
+
+	result := c.server.Exec(operation, args[0], args[1], ttl, 0)
+	switch result.Status {
+	case constants.StatusStored:
+		return c.Reply(RESPOK)
+	case constants.StatusNotStored:
+		return c.Null()
+	default:
+		return c.ReplyStatus(result.Status)
+	}
+}
+
+// Del handles DEL key [key ...] and returns the number of keys removed.
+func (c *RESPConn) Del(name string, args [][]byte) error {
+	if len(args) == 0 {
+		return c.ArityError(name)
+	}
+
+	return c.Integer(c.Count(constants.DeleteOperation, constants.StatusDeleted, args))
+}
+
+// Exists handles EXISTS key [key ...] and returns the number of keys present.
+// The keys are only peeked at, like in Redis they don't count as reads.
+func (c *RESPConn) Exists(name string, args [][]byte) error {
+	if len(args) == 0 {
+		return c.ArityError(name)
+	}
+
+	return c.Integer(c.Count(constants.PeekOperation, constants.StatusOK, args))
+}
+
+// Count runs the operation on every key and returns how many of them got the status.
+func (c *RESPConn) Count(operation, status byte, keys [][]byte) int64 {
+	replies := make([]Reply, 0, len(keys))
+	for _, key := range keys {
+		if IsKey(key) {
+			replies = append(replies, c.server.Submit(operation, key, nil, 0, 0))
+		}
+	}
+
+	var count int64
+	for _, reply := range replies {
+		if reply.Result().Status == status {
+			count++
+		}
+	}
+
+	return count
+}
+
+// Expire handles EXPIRE key seconds, returning 1 if the TTL was set.
+// An expiration time that is not positive deletes the key, like Redis does.
+func (c *RESPConn) Expire(name string, args [][]byte) error {
+	if len(args) != 2 {
+		return c.ArityError(name)
+	}
+
+	seconds, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return c.Reply(RESPNotInteger)
+	}
+
+	if !IsKey(args[0]) {
+		return c.Integer(0)
+	}
+
+	if seconds <= 0 {
+		return c.Integer(c.Count(constants.DeleteOperation, constants.StatusDeleted, args[:1]))
+	}
+
+	if seconds > math.MaxUint32 {
+		return c.Error(fmt.Sprintf("ERR invalid expire time in '%s' command", name))
+	}
+
+	result := c.server.Exec(constants.TouchOperation, args[0], nil, int(seconds), 0)
+	if result.Status != constants.StatusTouched {
+		return c.Integer(0)
+	}
+
+	return c.Integer(1)
+}
+
+// TTL handles TTL key: -2 if the key is missing, -1 if it never expires, else the seconds left.
+func (c *RESPConn) TTL(name string, args [][]byte) error {
+	if len(args) != 1 {
+		return c.ArityError(name)
+	}
+
+	if !IsKey(args[0]) {
+		return c.Integer(-2)
+	}
+
+	info, status := c.server.PeekInfo(args[0])
+	if status != constants.StatusOK {
+		return c.Integer(-2)
+	}
+
+	return c.Integer(info.TTL)
+}
+
+// MGet handles MGET key [key ...]
+func (c *RESPConn) MGet(name string, args [][]byte) error {
+	if len(args) == 0 {
+		return c.ArityError(name)
+	}
+
+	// Keys that can't be stored are misses, the others are looked up with one request.
+	keys := make([][]byte, 0, len(args))
+	for _, key := range args {
+		if IsKey(key) {
+			keys = append(keys, key)
+		}
+	}
+
+	results := c.server.GetMulti(keys)
+
+	c.Array(len(args))
+	for _, key := range args {
+		if !IsKey(key) {
+			c.Null()
+			continue
+		}
+
+		if result := results[0]; result.Status == constants.StatusOK {
+			c.Bulk(result.Value)
+		} else {
+			c.Null()
+		}
+
+		results = results[1:]
+	}
+
+	return nil
+}
+
+// MSet handles MSET key value [key value ...]
+func (c *RESPConn) MSet(name string, args [][]byte) error {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return c.ArityError(name)
+	}
+
+	for i := 0; i < len(args); i += 2 {
+		if !IsKey(args[i]) {
+			return c.Reply(RESPKeyTooLong)
+		}
+	}
+
+	replies := make([]Reply, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		replies = append(replies, c.server.Submit(constants.SetOperation, args[i], args[i+1], 0, 0))
+	}
+
+	// Report the first failure, the other pairs are still stored.
+	var failed byte
+	for _, reply := range replies {
+		if status := reply.Result().Status; status != constants.StatusStored && failed == 0 {
+			failed = status
+		}
+	}
+
+	if failed != 0 {
+		return c.ReplyStatus(failed)
+	}
+
+	return c.Reply(RESPOK)
+}
+
+// Ping handles PING [message]
+func (c *RESPConn) Ping(name string, args [][]byte) error {
+	switch len(args) {
+	case 0:
+		return c.Reply(RESPPong)
+	case 1:
+		return c.Bulk(args[0])
+	default:
+		return c.ArityError(name)
+	}
+}
+
+// Select handles SELECT index, only the database 0 exists.
+func (c *RESPConn) Select(name string, args [][]byte) error {
+	if len(args) != 1 {
+		return c.ArityError(name)
+	}
+
+	if string(args[0]) != "0" {
+		return c.Error("ERR DB index is out of range")
+	}
+
+	return c.Reply(RESPOK)
+}
+
+// Hello handles HELLO [protover], switching between RESP2 and RESP3 and describing the server.
+func (c *RESPConn) Hello(args [][]byte) error {
+	if len(args) > 0 {
+		version, err := strconv.Atoi(string(args[0]))
+		if err != nil || (version != 2 && version != 3) {
+			return c.Reply(RESPUnsupported)
+		}
+
+		c.version = version
+	}
+
+	fields := [][2]string{
+		{"server", "memcached"},
+		{"version", constants.Version},
+		{"proto", strconv.Itoa(c.version)},
+		{"mode", "standalone"},
+		{"role", "master"},
+	}
+
+	if c.version == 3 {
+		c.Reply("%" + strconv.Itoa(len(fields)+1) + "\r\n")
+	} else {
+		c.Array(2 * (len(fields) + 1))
+	}
+
+	for _, field := range fields {
+		c.Bulk([]byte(field[0]))
+		if field[0] == "proto" {
+			number, _ := strconv.ParseInt(field[1], 10, 64)
+			c.Integer(number)
+			continue
+		}
+
+		c.Bulk([]byte(field[1]))
+	}
+
+	c.Bulk([]byte("modules"))
+	return c.Array(0)
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/WatchJani/memCashed/memcached/constants"
+)
+
+func TestRESPProtocol(t *testing.T) {
+	s := newTestServer()
+
+	converse(t, s.HandleRESPConn, [][2]string{
+		{"*1\r\n$4\r\nPING\r\n", "+PONG\r\n"},
+		{"*3\r\n$3\r\nSET\r\n$3\r\nfoo\r\n$3\r\nbar\r\n", "+OK\r\n"},
+		{"*2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n", "$3\r\nbar\r\n"},
+		{"*4\r\n$3\r\nSET\r\n$3\r\nfoo\r\n$1\r\nx\r\n$2\r\nNX\r\n", "$-1\r\n"},
+		{"*4\r\n$3\r\nSET\r\n$3\r\nnew\r\n$1\r\nx\r\n$2\r\nXX\r\n", "$-1\r\n"},
+		{"*5\r\n$3\r\nSET\r\n$3\r\nfoo\r\n$3\r\nbaz\r\n$2\r\nEX\r\n$3\r\n100\r\n", "+OK\r\n"},
+		{"*2\r\n$3\r\nTTL\r\n$3\r\nfoo\r\n", ":100\r\n"},
+		{"*2\r\n$3\r\nTTL\r\n$3\r\nnew\r\n", ":-2\r\n"},
+		{"*3\r\n$6\r\nEXPIRE\r\n$3\r\nnew\r\n$2\r\n10\r\n", ":0\r\n"},
+		{"*3\r\n$6\r\nEXPIRE\r\n$3\r\nfoo\r\n$7\r\n5184000\r\n", ":1\r\n"},
+		{"*2\r\n$3\r\nTTL\r\n$3\r\nfoo\r\n", ":5184000\r\n"},
+		{"*3\r\n$6\r\nEXPIRE\r\n$3\r\nfoo\r\n$10\r\n4294967296\r\n", "-ERR invalid expire time in 'expire' command\r\n"},
+		{"*5\r\n$3\r\nSET\r\n$3\r\nfoo\r\n$3\r\nbaz\r\n$2\r\nPX\r\n$13\r\n4294967295000\r\n", "+OK\r\n"},
+		{"*5\r\n$3\r\nSET\r\n$3\r\nfoo\r\n$3\r\nbaz\r\n$2\r\nPX\r\n$13\r\n4294967295001\r\n", "-ERR invalid expire time in 'set' command\r\n"},
+		{"*5\r\n$4\r\nMSET\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n$1\r\n2\r\n", "+OK\r\n"},
+		{"*4\r\n$4\r\nMGET\r\n$1\r\na\r\n$3\r\nnew\r\n$1\r\nb\r\n", "*3\r\n$1\r\n1\r\n$-1\r\n$1\r\n2\r\n"},
+		{"*4\r\n$6\r\nEXISTS\r\n$1\r\na\r\n$1\r\nb\r\n$3\r\nnew\r\n", ":2\r\n"},
+		{"*3\r\n$3\r\nDEL\r\n$1\r\na\r\n$3\r\nnew\r\n", ":1\r\n"},
+		{"*2\r\n$5\r\nHELLO\r\n$1\r\n4\r\n", "-NOPROTO unsupported protocol version\r\n"},
+		{"PING\r\n", "+PONG\r\n"},
+		{"*1\r\n$3\r\nFOO\r\n", "-ERR unknown command 'foo'\r\n"},
+	})
+}
+
+func TestRESPExistsIsNotARead(t *testing.T) {
+	s := newTestServer()
+
+	converse(t, s.HandleRESPConn, [][2]string{
+		{"*5\r\n$3\r\nSET\r\n$3\r\nfoo\r\n$3\r\nbar\r\n$2\r\nEX\r\n$3\r\n100\r\n", "+OK\r\n"},
+		{"*3\r\n$6\r\nEXISTS\r\n$3\r\nfoo\r\n$3\r\nnew\r\n", ":1\r\n"},
+		{"*2\r\n$3\r\nTTL\r\n$3\r\nfoo\r\n", ":100\r\n"},
+	})
+
+	// like in Redis, neither command counts as a read of the key
+	if stats := s.Manager.Stats(); stats.CmdGet.Load() != 0 || stats.GetHits.Load() != 0 || stats.GetMisses.Load() != 0 {
+		t.Errorf("stats: expected no gets | get %d gets, %d hits, %d misses", stats.CmdGet.Load(), stats.GetHits.Load(), stats.GetMisses.Load())
+	}
+
+	if info, status := s.PeekInfo([]byte("foo")); status != constants.StatusOK || info.Fetched {
+		t.Errorf("foo: expected never read | get status %d, fetched %t", status, info.Fetched)
+	}
+}
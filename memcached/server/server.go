@@ -1,11 +1,14 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net"
 	"sync"
+	"time"
 
 	"github.com/WatchJani/memCashed/memcached/constants"
 	"github.com/WatchJani/memCashed/memcached/internal/types"
@@ -16,69 +19,157 @@ import (
 // Server represents a server that handles TCP connections, manages active connections,
 // and uses a memory allocator for efficient data handling.
 type Server struct {
-	Add        string // Address and port the server binds to.
-	MaxConn    int    // Maximum number of allowed active connections.
-	ActiveConn int    // Current number of active connections.
+	Listeners  []types.Listener // Ports the server binds to, each with its protocol.
+	MaxConn    int              // Maximum number of allowed active connections.
+	MaxMemory  int              // Highest memory limit in bytes the cache can be resized to.
+	ActiveConn int              // Current number of active connections.
+	Start      time.Time        // Time the server was started.
 	sync.RWMutex
 	Manager *memory_allocator.SlabManager // Memory allocator for managing slab memory.
 }
 
 // New initializes a new Server instance by loading the configuration
 // and setting up the slab memory allocator.
-func New() *Server {
+func New() (*Server, error) {
 	// Load server configuration.
 	config := types.LoadConfiguration()
 
+	listeners, err := config.Listeners()
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := config.Policy()
+	if err != nil {
+		return nil, err
+	}
+
 	// Initialize the memory allocator using the configuration.
-	newAllocator := config.MemoryAllocator()
+	newAllocator, err := config.MemoryAllocator()
+	if err != nil {
+		return nil, err
+	}
+
+	if config.HugePages {
+		if err := newAllocator.EnableHugePages(); err != nil {
+			log.Println(err) // The cache still works on normal pages
+		}
+	}
+
+	manager := memory_allocator.NewShardedSlabManager(
+		config.Slabs(newAllocator), // Initialize the slab memory with the configured settings.
+		config.NumberWorker(),      // Set the number of workers for slab management.
+		config.NumberShards(),      // Split the keys into partitions with their own locks.
+		policy,                     // Evict by the configured policy.
+	)
+
+	// Report the slab classes, chunks that don't fill a page leave the rest of it unused
+	for i, slab := range manager.SlabStats() {
+		log.Printf("slab class %3d: chunk size %9d perslab %7d max pages %d",
+			i+1, slab.ChunkSize, constants.MiB/slab.ChunkSize, slab.MaxPages)
+	}
+
+	if config.Admission {
+		manager.EnableAdmission()
+	}
+
+	if config.SlabAutomove {
+		go manager.Automove()
+	}
 
 	// Create a new Server instance with the provided configuration and memory manager.
 	return &Server{
-		Add:     config.Port(),
-		MaxConn: config.MaxConnection(),
-		Manager: memory_allocator.NewSlabManager(
-			config.Slabs(newAllocator), // Initialize the slab memory with the configured settings.
-			config.NumberWorker(),      // Set the number of workers for slab management.
-		),
-	}
+		Listeners: listeners,
+		MaxConn:   config.MaxConnection(),
+		MaxMemory: config.MemoryLimit(),
+		Start:     time.Now(),
+		Manager:   manager,
+	}, nil
 }
 
-// Run starts the server, listens for incoming TCP connections,
-// and handles them concurrently. It also enforces a maximum connection limit.
+// Run starts a listener for every configured port and handles the incoming
+// connections with the protocol of their port. It returns when a listener stops.
 func (s *Server) Run() error {
-	// Start listening for incoming TCP connections on the specified address.
-	ls, err := net.Listen(constants.TCP, s.Add)
-	if err != nil {
-		return err // Return error if the server fails to start listening.
+	errCh := make(chan error, len(s.Listeners))
+
+	for _, listener := range s.Listeners {
+		handler := s.Handler(listener.Protocol)
+
+		// Start listening for incoming TCP connections on the specified address.
+		ls, err := net.Listen(constants.TCP, listener.Addr())
+		if err != nil {
+			return err // Return error if the server fails to start listening.
+		}
+
+		// Ensure the listener is closed properly when the function ends.
+		defer Close(ls, constants.InfoServerClose)
+
+		go func() {
+			errCh <- s.Serve(ls, handler)
+		}()
 	}
 
-	// Ensure the listener is closed properly when the function ends.
-	defer Close(ls, constants.InfoServerClose)
+	return <-errCh
+}
 
+// Handler returns the connection handler for the protocol.
+func (s *Server) Handler(protocol string) func(net.Conn) {
+	switch protocol {
+	case constants.ProtocolText:
+		return s.HandleTextConn
+	case constants.ProtocolRESP:
+		return s.HandleRESPConn
+	default:
+		return s.HandleConn
+	}
+}
+
+// Serve accepts connections on the listener and handles them concurrently.
+// It also enforces a maximum connection limit.
+func (s *Server) Serve(ls net.Listener, handler func(net.Conn)) error {
 	// Infinite loop to accept and handle incoming connections.
 	for {
 		// Accept an incoming connection.
 		conn, err := ls.Accept()
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return err // The listener is closed, stop accepting.
+			}
+
 			log.Println(err) // Log any connection errors.
 			continue         // Continue accepting other connections.
 		}
 
 		// Increment the active connection count.
-		s.ActiveConn++
-
 		// If the active connection count exceeds the maximum limit, close the connection.
-		if s.ActiveConn > s.MaxConn {
+		if s.increase() > s.MaxConn {
 			if err := conn.Close(); err != nil {
 				log.Println(err)
 			}
 		}
 
 		// Handle the connection in a separate goroutine.
-		go s.HandleConn(conn)
+		go handler(conn)
 	}
 }
 
+// increase raises the active connection count by one and returns the new count.
+func (s *Server) increase() int {
+	s.Lock()
+	defer s.Unlock()
+
+	s.ActiveConn++
+	return s.ActiveConn
+}
+
+// Connections returns the current number of active connections.
+func (s *Server) Connections() int {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.ActiveConn
+}
+
 // decrease reduces the active connection count by one.
 // This method ensures thread-safety using a write lock.
 func (s *Server) decrease() {
@@ -112,10 +203,13 @@ func (s *Server) HandleConn(conn net.Conn) {
 	// Buffer to hold the first 4 bytes, which indicates the payload size.
 	bufSize := make([]byte, constants.BufferSizeTCP)
 
+	// Buffer to hold the header and the key of a request, read before its chunk is allocated.
+	prefix := make([]byte, constants.HeaderSize+math.MaxUint8)
+
 	// Infinite loop to continuously read data from the connection.
 	for {
 		// Read the first 4 bytes (the length of the payload).
-		_, err := conn.Read(bufSize)
+		_, err := io.ReadFull(conn, bufSize)
 		if err != nil {
 			// If an error occurs during reading (excluding EOF), log it.
 			if err != io.EOF {
@@ -128,14 +222,31 @@ func (s *Server) HandleConn(conn net.Conn) {
 		// Decode the payload size from the received length bytes.
 		payloadSize := decoder.DecodeLength(bufSize)
 
+		// The admission filter decides by the key, so the header and the key are read first.
+		read, err := ReadPrefix(conn, prefix, payloadSize)
+		if err != nil {
+			break
+		}
+
+		operation, key := prefix[0], prefix[min(read, constants.HeaderSize):read]
+
 		// Get a slab block and its index from the memory allocator.
-		slabBlock, index, err := s.Manager.GetSlab(payloadSize, conn)
+		slabBlock, index, err := s.Manager.GetSlabFor(payloadSize, operation, key)
 		if err != nil {
 			log.Println(err) // Log error if slab memory allocation fails.
+
+			// The request still has to be read to the end before the next one.
+			if _, err := io.CopyN(io.Discard, conn, int64(payloadSize-read)); err != nil {
+				break
+			}
+
+			memory_allocator.Respond(conn, decoder.RequestID(prefix), memory_allocator.StatusOf(err), nil)
+			continue
 		}
 
-		// Read the actual payload data into the slab block.
-		_, err = conn.Read(slabBlock)
+		// Read the rest of the payload data into the slab block, behind the header and the key.
+		copy(slabBlock, prefix[:read])
+		_, err = io.ReadFull(conn, slabBlock[read:payloadSize])
 		// If an error occurs during reading the payload (excluding EOF), log it.
 		if err != nil {
 			if err != io.EOF {
@@ -150,9 +261,31 @@ func (s *Server) HandleConn(conn net.Conn) {
 	}
 }
 
-// Req sends a processed request to the slab manager's job channel,
+// ReadPrefix reads the header and the key of a request into the prefix and returns how many bytes it read.
+// A request too short for a header leaves the rest of the header zeroed.
+func ReadPrefix(conn net.Conn, prefix []byte, payloadSize int) (int, error) {
+	read := min(payloadSize, constants.HeaderSize)
+	if _, err := io.ReadFull(conn, prefix[:read]); err != nil {
+		return 0, err
+	}
+
+	if read < constants.HeaderSize {
+		clear(prefix[read:constants.HeaderSize])
+		return read, nil
+	}
+
+	_, keySize, _, _ := decoder.Decode(prefix)
+	end := read + min(int(keySize), payloadSize-read)
+	if _, err := io.ReadFull(conn, prefix[read:end]); err != nil {
+		return 0, err
+	}
+
+	return end, nil
+}
+
+// Req sends a processed request to the job channel of the shard holding its key,
 // including the payload, index, and connection.
-func (s *Server) Req(buf []byte, index int, conn net.Conn) {
-	// Create a new transfer object and send it to the job channel for further processing.
-	s.Manager.JobCh <- memory_allocator.NewTransfer(buf, index, conn)
+func (s *Server) Req(buf []byte, index int, conn io.Writer) {
+	// Create a new transfer object and send it to the workers of its shard for further processing.
+	s.Manager.Dispatch(memory_allocator.NewTransfer(buf, index, conn))
 }
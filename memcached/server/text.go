@@ -0,0 +1,597 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/WatchJani/memCashed/memcached/constants"
+	decoder "github.com/WatchJani/memCashed/memcached/parser"
+)
+
+// Replies of the memcached text protocol.
+const (
+	TextStored      = "STORED\r\n"
+	TextNotStored   = "NOT_STORED\r\n"
+	TextExists      = "EXISTS\r\n"
+	TextDeleted     = "DELETED\r\n"
+	TextTouched     = "TOUCHED\r\n"
+	TextNotFound    = "NOT_FOUND\r\n"
+	TextEnd         = "END\r\n"
+	TextError       = "ERROR\r\n"
+	TextBadFormat   = "CLIENT_ERROR bad command line format\r\n"
+	TextBadChunk    = "CLIENT_ERROR bad data chunk\r\n"
+	TextNonNumeric  = "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n"
+	TextOutOfMemory = "SERVER_ERROR out of memory storing object\r\n"
+	TextTooLarge    = "SERVER_ERROR object too large for cache\r\n"
+	TextRejected    = "SERVER_ERROR object rejected by the admission filter\r\n"
+	TextOK          = "OK\r\n"
+	TextOverLimit   = "CLIENT_ERROR memory limit over the configured maximum\r\n"
+	TextLineTooLong = "CLIENT_ERROR line too long\r\n"
+
+	MaxRelativeExpiration = 60 * 60 * 24 * 30 // Longer expiration times are unix timestamps
+)
+
+// errQuit is returned by a command that closes the connection.
+var errQuit = errors.New("quit")
+
+// TextConn is a connection speaking the memcached ASCII text protocol.
+type TextConn struct {
+	*bufio.Reader // Buffered reader of the connection.
+	*bufio.Writer // Buffered writer of the connection.
+	server        *Server
+}
+
+// HandleTextConn processes a connection speaking the memcached ASCII text protocol.
+// Every command is translated into the same requests the binary protocol sends to the workers.
+func (s *Server) HandleTextConn(conn net.Conn) {
+	// Ensure the connection is closed and the active connection count is reduced when done.
+	defer func() {
+		Close(conn, constants.InfoConnectionClose)
+		s.decrease()
+	}()
+
+	c := TextConn{
+		Reader: bufio.NewReaderSize(conn, constants.MaxLineLength),
+		Writer: bufio.NewWriter(conn),
+		server: s,
+	}
+
+	for {
+		line, err := c.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			// A line longer than the reader is refused, the rest of it is skipped up to the newline.
+			line, err = nil, c.SkipLine()
+		}
+
+		if err != nil {
+			// If an error occurs during reading (excluding EOF), log it.
+			if err != io.EOF {
+				log.Println(err)
+			}
+
+			break // Exit the loop if reading fails.
+		}
+
+		if line == nil {
+			err = c.Reply(TextLineTooLong)
+		} else {
+			// The line is copied, commands that read a data block refill the buffer it points into.
+			err = c.Command(bytes.Fields(bytes.Clone(line)))
+		}
+
+		if err != nil {
+			if err != errQuit {
+				log.Println(err)
+			}
+
+			c.Flush() // Send the replies of the commands before it
+			break
+		}
+
+		// Send the replies once there are no more pipelined commands to run.
+		if c.Reader.Buffered() == 0 {
+			if err := c.Flush(); err != nil {
+				log.Println(err)
+				break
+			}
+		}
+	}
+}
+
+// SkipLine discards the rest of a line that doesn't fit the reader, up to and including the newline.
+func (c *TextConn) SkipLine() error {
+	for {
+		if _, err := c.ReadSlice('\n'); err != bufio.ErrBufferFull {
+			return err
+		}
+	}
+}
+
+// Command runs a single command line.
+func (c *TextConn) Command(fields [][]byte) error {
+	if len(fields) == 0 {
+		return c.Reply(TextError)
+	}
+
+	args := fields[1:]
+
+	switch string(fields[0]) {
+	case "get":
+		return c.Get(args, false)
+	case "gets":
+		return c.Get(args, true)
+	case "gat":
+		return c.GetAndTouch(args, false)
+	case "gats":
+		return c.GetAndTouch(args, true)
+	case "set":
+		return c.Store(constants.SetOperation, args)
+	case "add":
+		return c.Store(constants.AddOperation, args)
+	case "replace":
+		return c.Store(constants.ReplaceOperation, args)
+	case "append":
+		return c.Store(constants.AppendOperation, args)
+	case "prepend":
+		return c.Store(constants.PrependOperation, args)
+	case "cas":
+		return c.Store(constants.CompareAndSetOperation, args)
+	case "delete":
+		return c.Delete(args)
+	case "touch":
+		return c.Touch(args)
+	case "incr":
+		return c.Arithmetic(constants.IncrementOperation, args)
+	case "decr":
+		return c.Arithmetic(constants.DecrementOperation, args)
+	case "stats":
+		return c.Stats(args)
+	case "mg":
+		return c.MetaGet(args)
+	case "ms":
+		return c.MetaSet(args)
+	case "md":
+		return c.MetaDelete(args)
+	case "ma":
+		return c.MetaArithmetic(args)
+	case "mn":
+		return c.Reply(MetaNoOp)
+	case "cache_memlimit":
+		return c.CacheMemLimit(args)
+	case "version":
+		return c.Reply("VERSION " + constants.Version + "\r\n")
+	case "quit":
+		return errQuit
+	default:
+		return c.Reply(TextError)
+	}
+}
+
+// Reply writes a reply to the connection's buffer.
+func (c *TextConn) Reply(reply string) error {
+	_, err := c.WriteString(reply)
+	return err
+}
+
+// ReplyStatus writes the reply for the status of a request, unless the client asked for no reply.
+func (c *TextConn) ReplyStatus(status byte, noReply bool) error {
+	if noReply {
+		return nil
+	}
+
+	switch status {
+	case constants.StatusStored:
+		return c.Reply(TextStored)
+	case constants.StatusNotStored:
+		return c.Reply(TextNotStored)
+	case constants.StatusExists:
+		return c.Reply(TextExists)
+	case constants.StatusDeleted:
+		return c.Reply(TextDeleted)
+	case constants.StatusTouched:
+		return c.Reply(TextTouched)
+	case constants.StatusNotFound, constants.StatusExpired:
+		return c.Reply(TextNotFound)
+	case constants.StatusNonNumeric:
+		return c.Reply(TextNonNumeric)
+	case constants.StatusNotEnoughSpace:
+		return c.Reply(TextOutOfMemory)
+	case constants.StatusTooLarge:
+		return c.Reply(TextTooLarge)
+	case constants.StatusRejected:
+		return c.Reply(TextRejected)
+	default:
+		return c.Reply(TextError)
+	}
+}
+
+// IsConcat checks if the operation adds data to a stored value, which keeps its flags and expiration time.
+func IsConcat(operation byte) bool {
+	return operation == constants.AppendOperation || operation == constants.PrependOperation
+}
+
+// IsKey checks if the key can be stored.
+func IsKey(key []byte) bool {
+	return len(key) > 0 && len(key) <= constants.MaxKeyLength
+}
+
+// NoReply checks if the optional last argument asks the server not to reply.
+func NoReply(args [][]byte, position int) bool {
+	return len(args) > position && string(args[position]) == "noreply"
+}
+
+// Expiration converts a memcached expiration time into the TTL in seconds used by the binary protocol.
+// Expiration times over 30 days are unix timestamps. It reports false if the object is already expired.
+func Expiration(exptime int64) (int, bool) {
+	if exptime < 0 {
+		return 0, false
+	}
+
+	if exptime > MaxRelativeExpiration {
+		exptime -= time.Now().Unix()
+		if exptime <= 0 {
+			return 0, false
+		}
+	}
+
+	return int(exptime), true
+}
+
+// Get handles get <key>* and gets <key>*.
+func (c *TextConn) Get(keys [][]byte, withCAS bool) error {
+	if len(keys) == 0 {
+		return c.Reply(TextError)
+	}
+
+	for _, key := range keys {
+		if !IsKey(key) {
+			return c.Reply(TextBadFormat)
+		}
+	}
+
+	// Every key is looked up with one request.
+	return c.Values(keys, c.server.GetMulti(keys), withCAS)
+}
+
+// GetAndTouch handles gat <exptime> <key>* and gats <exptime> <key>*.
+func (c *TextConn) GetAndTouch(args [][]byte, withCAS bool) error {
+	if len(args) < 2 {
+		return c.Reply(TextError)
+	}
+
+	exptime, err := strconv.ParseInt(string(args[0]), 10, 64)
+	if err != nil {
+		return c.Reply(TextBadFormat)
+	}
+
+	keys := args[1:]
+	for _, key := range keys {
+		if !IsKey(key) {
+			return c.Reply(TextBadFormat)
+		}
+	}
+
+	ttl, alive := Expiration(exptime)
+
+	// Submit every key before waiting, so the workers can look them up in parallel.
+	replies := make([]Reply, len(keys))
+	for i, key := range keys {
+		replies[i] = c.server.Submit(constants.GetAndTouchOperation, key, nil, ttl, 0)
+	}
+
+	results := make([]Result, len(keys))
+	for i, reply := range replies {
+		results[i] = reply.Result()
+
+		// Touching with an expiration in the past expires the object right after it is read.
+		if !alive && results[i].Status == constants.StatusOK {
+			c.server.Exec(constants.DeleteOperation, keys[i], nil, 0, 0)
+		}
+	}
+
+	return c.Values(keys, results, withCAS)
+}
+
+// Values writes the values found for the keys, followed by the end of the reply.
+func (c *TextConn) Values(keys [][]byte, results []Result, withCAS bool) error {
+	for i, result := range results {
+		if result.Status != constants.StatusOK {
+			continue
+		}
+
+		if withCAS {
+			fmt.Fprintf(c, "VALUE %s %d %d %d\r\n", keys[i], result.Flags, len(result.Value), result.CAS)
+		} else {
+			fmt.Fprintf(c, "VALUE %s %d %d\r\n", keys[i], result.Flags, len(result.Value))
+		}
+
+		c.Write(result.Value)
+		c.WriteString("\r\n")
+	}
+
+	return c.Reply(TextEnd)
+}
+
+// Store handles set, add, replace, append and prepend: <command> <key> <flags> <exptime> <bytes> [noreply]
+// and cas: cas <key> <flags> <exptime> <bytes> <cas unique> [noreply]
+func (c *TextConn) Store(operation byte, args [][]byte) error {
+	noReplyPosition := 4
+	if operation == constants.CompareAndSetOperation {
+		noReplyPosition = 5
+	}
+
+	if len(args) < noReplyPosition || !IsKey(args[0]) {
+		return c.Reply(TextBadFormat)
+	}
+
+	flags, errFlags := strconv.ParseUint(string(args[1]), 10, 32)
+	exptime, errExptime := strconv.ParseInt(string(args[2]), 10, 64)
+	size, errSize := strconv.Atoi(string(args[3]))
+	if errFlags != nil || errExptime != nil || errSize != nil || size < 0 {
+		return c.Reply(TextBadFormat)
+	}
+
+	// Read the data block and the \r\n that ends it.
+	data, err := c.ReadData(size)
+	if err != nil || data == nil {
+		return err
+	}
+
+	key, value, noReply := args[0], data, NoReply(args, noReplyPosition)
+
+	if operation == constants.CompareAndSetOperation {
+		unique, err := strconv.ParseUint(string(args[4]), 10, 64)
+		if err != nil {
+			return c.Reply(TextBadFormat)
+		}
+
+		value = decoder.PrependCAS(unique, value)
+	}
+
+	if IsConcat(operation) {
+		exptime = 0 // The object keeps its expiration time
+	}
+
+	ttl, alive := Expiration(exptime)
+	if !alive {
+		return c.ReplyStatus(c.StoreExpired(operation, key, value), noReply)
+	}
+
+	result := c.server.Exec(operation, key, value, ttl, uint32(flags))
+	return c.ReplyStatus(result.Status, noReply)
+}
+
+// ReadData reads a data block of the size and the \r\n that ends it. A block larger than any chunk
+// is read and thrown away, like a block that doesn't end with \r\n, and the error is replied.
+// It returns nil data after replying.
+func (c *TextConn) ReadData(size int) ([]byte, error) {
+	if size > c.server.Manager.MaxPayload() {
+		if _, err := io.CopyN(io.Discard, c.Reader, int64(size)); err != nil {
+			return nil, err
+		}
+
+		if _, err := io.CopyN(io.Discard, c.Reader, 2); err != nil {
+			return nil, err
+		}
+
+		return nil, c.Reply(TextTooLarge)
+	}
+
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(c.Reader, data); err != nil {
+		return nil, err
+	}
+
+	if !bytes.HasSuffix(data, []byte("\r\n")) {
+		return nil, c.Reply(TextBadChunk)
+	}
+
+	return data[:size], nil
+}
+
+// StoreExpired handles a store of an object that is already expired, which leaves the key absent.
+// It returns the status the store would have had.
+func (c *TextConn) StoreExpired(operation byte, key, value []byte) byte {
+	if operation == constants.CompareAndSetOperation {
+		// The object is only removed if the CAS value still matches.
+		if status := c.server.Exec(operation, key, value, 0, 0).Status; status != constants.StatusStored {
+			return status
+		}
+
+		c.server.Exec(constants.DeleteOperation, key, nil, 0, 0)
+		return constants.StatusStored
+	}
+
+	if operation == constants.AddOperation {
+		// Add is refused while the key is present, otherwise there is nothing to store.
+		if c.server.Exec(constants.GetOperation, key, nil, 0, 0).Status == constants.StatusOK {
+			return constants.StatusNotStored
+		}
+
+		return constants.StatusStored
+	}
+
+	deleted := c.server.Exec(constants.DeleteOperation, key, nil, 0, 0).Status == constants.StatusDeleted
+	if operation == constants.ReplaceOperation && !deleted {
+		return constants.StatusNotStored // Replace is refused while the key is absent
+	}
+
+	return constants.StatusStored
+}
+
+// Delete handles delete <key> [noreply]
+func (c *TextConn) Delete(args [][]byte) error {
+	if len(args) < 1 || !IsKey(args[0]) {
+		return c.Reply(TextBadFormat)
+	}
+
+	result := c.server.Exec(constants.DeleteOperation, args[0], nil, 0, 0)
+	return c.ReplyStatus(result.Status, NoReply(args, len(args)-1))
+}
+
+// Touch handles touch <key> <exptime> [noreply]
+func (c *TextConn) Touch(args [][]byte) error {
+	if len(args) < 2 || !IsKey(args[0]) {
+		return c.Reply(TextBadFormat)
+	}
+
+	exptime, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return c.Reply(TextBadFormat)
+	}
+
+	noReply := NoReply(args, 2)
+
+	ttl, alive := Expiration(exptime)
+	if !alive {
+		// Touching with an expiration in the past expires the object right away.
+		if c.server.Exec(constants.DeleteOperation, args[0], nil, 0, 0).Status == constants.StatusDeleted {
+			return c.ReplyStatus(constants.StatusTouched, noReply)
+		}
+
+		return c.ReplyStatus(constants.StatusNotFound, noReply)
+	}
+
+	result := c.server.Exec(constants.TouchOperation, args[0], nil, ttl, 0)
+	return c.ReplyStatus(result.Status, noReply)
+}
+
+// Arithmetic handles incr and decr: <command> <key> <value> [noreply]
+func (c *TextConn) Arithmetic(operation byte, args [][]byte) error {
+	if len(args) < 2 || !IsKey(args[0]) {
+		return c.Reply(TextBadFormat)
+	}
+
+	delta, err := strconv.ParseUint(string(args[1]), 10, 64)
+	if err != nil {
+		return c.Reply("CLIENT_ERROR invalid numeric delta argument\r\n")
+	}
+
+	body := decoder.EncodeCounter(delta, constants.CounterDecimal)
+
+	result := c.server.Exec(operation, args[0], body, 0, 0)
+	if result.Status != constants.StatusOK {
+		return c.ReplyStatus(result.Status, NoReply(args, 2))
+	}
+
+	if NoReply(args, 2) {
+		return nil
+	}
+
+	return c.Reply(strconv.FormatUint(decoder.LittleEndianDecode64(result.Value), 10) + "\r\n")
+}
+
+// Stats handles stats, reporting the server and slab manager counters.
+func (c *TextConn) Stats(args [][]byte) error {
+	if len(args) == 1 && string(args[0]) == "slabs" {
+		return c.SlabStats()
+	}
+
+	if len(args) == 1 && string(args[0]) == "items" {
+		return c.ItemStats()
+	}
+
+	if len(args) > 0 {
+		return c.Reply(TextError)
+	}
+
+	now := time.Now()
+	fmt.Fprintf(c, "STAT pid %d\r\n", os.Getpid())
+	fmt.Fprintf(c, "STAT uptime %d\r\n", int64(now.Sub(c.server.Start).Seconds()))
+	fmt.Fprintf(c, "STAT time %d\r\n", now.Unix())
+	fmt.Fprintf(c, "STAT version %s\r\n", constants.Version)
+	fmt.Fprintf(c, "STAT curr_connections %d\r\n", c.server.Connections())
+	fmt.Fprintf(c, "STAT threads %d\r\n", c.server.Manager.Workers())
+	fmt.Fprintf(c, "STAT limit_maxbytes %d\r\n", c.server.Manager.LimitMaxBytes())
+	fmt.Fprintf(c, "STAT hash_bytes %d\r\n", c.server.Manager.HashBytes())
+
+	for _, stat := range c.server.Manager.Stats().Snapshot() {
+		fmt.Fprintf(c, "STAT %s %d\r\n", stat.Name, stat.Value)
+	}
+
+	return c.Reply(TextEnd)
+}
+
+// CacheMemLimit handles cache_memlimit <megabytes>, changing the memory limit of the cache while it runs.
+func (c *TextConn) CacheMemLimit(args [][]byte) error {
+	if len(args) != 1 {
+		return c.Reply(TextBadFormat)
+	}
+
+	megabytes, err := strconv.ParseUint(string(args[0]), 10, 32)
+	if err != nil {
+		return c.Reply(TextBadFormat)
+	}
+
+	// Every limit up to the maximum can be mapped, anything above is refused before it is tried
+	limit := int(megabytes) * constants.MiB
+	if limit > c.server.MaxMemory {
+		return c.Reply(TextOverLimit)
+	}
+
+	if err := c.server.Manager.Resize(limit); err != nil {
+		return c.Reply("SERVER_ERROR " + err.Error() + "\r\n")
+	}
+
+	return c.Reply(TextOK)
+}
+
+// SlabStats reports the state and the memory limit of every slab class, numbered from 1.
+func (c *TextConn) SlabStats() error {
+	active, malloced := 0, 0
+	for i, slab := range c.server.Manager.SlabStats() {
+		if slab.Pages == 0 && slab.MaxPages == 0 {
+			continue
+		}
+
+		active++
+		malloced += slab.Pages * constants.MiB
+		class := i + 1
+
+		fmt.Fprintf(c, "STAT %d:chunk_size %d\r\n", class, slab.ChunkSize)
+		fmt.Fprintf(c, "STAT %d:chunks_per_page %d\r\n", class, constants.MiB/slab.ChunkSize)
+		fmt.Fprintf(c, "STAT %d:total_pages %d\r\n", class, slab.Pages)
+		fmt.Fprintf(c, "STAT %d:max_pages %d\r\n", class, slab.MaxPages)
+		fmt.Fprintf(c, "STAT %d:used_chunks %d\r\n", class, slab.UsedChunks)
+		fmt.Fprintf(c, "STAT %d:free_chunks %d\r\n", class, slab.FreeChunks)
+		fmt.Fprintf(c, "STAT %d:evicted %d\r\n", class, slab.Evictions)
+		fmt.Fprintf(c, "STAT %d:outofmemory %d\r\n", class, slab.OutOfMemory)
+	}
+
+	fmt.Fprintf(c, "STAT active_slabs %d\r\n", active)
+	fmt.Fprintf(c, "STAT total_malloced %d\r\n", malloced)
+
+	return c.Reply(TextEnd)
+}
+
+// ItemStats reports the objects of every slab class holding any, by segment of its LRU.
+// Eviction policies without segments only report the number of objects.
+func (c *TextConn) ItemStats() error {
+	for i, slab := range c.server.Manager.SlabStats() {
+		if slab.Items == 0 {
+			continue
+		}
+
+		class := i + 1
+
+		fmt.Fprintf(c, "STAT items:%d:number %d\r\n", class, slab.Items)
+		if slab.Hot+slab.Warm+slab.Cold == slab.Items {
+			fmt.Fprintf(c, "STAT items:%d:number_hot %d\r\n", class, slab.Hot)
+			fmt.Fprintf(c, "STAT items:%d:number_warm %d\r\n", class, slab.Warm)
+			fmt.Fprintf(c, "STAT items:%d:number_cold %d\r\n", class, slab.Cold)
+		}
+
+		fmt.Fprintf(c, "STAT items:%d:evicted %d\r\n", class, slab.Evictions)
+		fmt.Fprintf(c, "STAT items:%d:outofmemory %d\r\n", class, slab.OutOfMemory)
+	}
+
+	return c.Reply(TextEnd)
+}
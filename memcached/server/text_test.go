@@ -0,0 +1,215 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/WatchJani/memCashed/memcached/constants"
+	"github.com/WatchJani/memCashed/memcached/internal/types"
+	"github.com/WatchJani/memCashed/memcached/memory_allocator"
+)
+
+func newTestServer() *Server {
+	allocator, err := memory_allocator.New(16 * 1024 * 1024)
+	if err != nil {
+		panic(err)
+	}
+
+	config := types.NewConfig()
+	return &Server{
+		MaxConn:   1,
+		MaxMemory: 64 * constants.MiB,
+		Start:     time.Now(),
+		Manager:   memory_allocator.NewSlabManager(config.Slabs(allocator), 2),
+	}
+}
+
+// converse sends each command over the handler and checks the reply it gets back.
+func converse(t *testing.T, handler func(net.Conn), conversation [][2]string) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	go handler(server)
+
+	reader := bufio.NewReader(client)
+	for _, step := range conversation {
+		if _, err := client.Write([]byte(step[0])); err != nil {
+			t.Fatal(err)
+		}
+
+		reply := make([]byte, len(step[1]))
+		if _, err := io.ReadFull(reader, reply); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := string(reply); got != step[1] {
+			t.Errorf("%q: expected %q | get %q", step[0], step[1], got)
+		}
+	}
+}
+
+func TestTextProtocol(t *testing.T) {
+	s := newTestServer()
+
+	converse(t, s.HandleTextConn, [][2]string{
+		{"set foo 5 0 3\r\nbar\r\n", "STORED\r\n"},
+		{"get foo missing\r\n", "VALUE foo 5 3\r\nbar\r\nEND\r\n"},
+		{"add foo 0 0 1\r\nx\r\n", "NOT_STORED\r\n"},
+		{"replace missing 0 0 1\r\nx\r\n", "NOT_STORED\r\n"},
+		{"replace missing 0 -1 1\r\nx\r\n", "NOT_STORED\r\n"},
+		{"replace foo 1 0 3\r\nbaz\r\nget foo\r\n", "STORED\r\nVALUE foo 1 3\r\nbaz\r\nEND\r\n"},
+		{"append foo 0 0 2\r\n!!\r\n", "STORED\r\n"},
+		{"prepend foo 0 -1 2\r\n<<\r\nget foo\r\n", "STORED\r\nVALUE foo 1 7\r\n<<baz!!\r\nEND\r\n"},
+		{"append missing 0 0 1\r\nx\r\n", "NOT_STORED\r\n"},
+		{"set counter 0 0 2\r\n10\r\n", "STORED\r\n"},
+		{"incr counter 5\r\n", "15\r\n"},
+		{"decr counter 6\r\n", "9\r\n"},
+		{"decr counter 100\r\n", "0\r\n"},
+		{"incr counter 15\r\n", "15\r\n"},
+		{"decr missing 1\r\n", "NOT_FOUND\r\n"},
+		{"incr foo 1\r\n", "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n"},
+		{"touch counter 100\r\n", "TOUCHED\r\n"},
+		{"gat 200 counter missing\r\n", "VALUE counter 0 2\r\n15\r\nEND\r\n"},
+		{"gats -1 counter\r\n", "VALUE counter 0 2 9\r\n15\r\nEND\r\n"},
+		{"get counter\r\n", "END\r\n"},
+		{"set counter 0 0 2\r\n15\r\n", "STORED\r\n"},
+		{"delete counter\r\n", "DELETED\r\n"},
+		{"delete counter\r\n", "NOT_FOUND\r\n"},
+		{"set foo 0 0 1 noreply\r\nx\r\nget foo\r\n", "VALUE foo 0 1\r\nx\r\nEND\r\n"},
+		{"bogus\r\n", "ERROR\r\n"},
+	})
+}
+
+func TestTextPipelinedStores(t *testing.T) {
+	s := newTestServer()
+
+	// Enough pipelined stores to refill the reader's buffer while a data block is read
+	var commands strings.Builder
+	for i := range 200 {
+		fmt.Fprintf(&commands, "set key%d 0 0 3000 noreply\r\n%s\r\n", i%10, strings.Repeat("v", 3000))
+	}
+
+	converse(t, s.HandleTextConn, [][2]string{
+		{commands.String() + "get key9\r\n", "VALUE key9 0 3000\r\n" + strings.Repeat("v", 3000) + "\r\nEND\r\n"},
+	})
+
+	if items := s.Manager.Stats().CurrItems.Load(); items != 10 {
+		t.Errorf("current items: expected 10 | get %d", items)
+	}
+}
+
+func TestTextPipelinedQuit(t *testing.T) {
+	s := newTestServer()
+
+	// The replies of the commands sent together with quit arrive before the connection is closed
+	converse(t, s.HandleTextConn, [][2]string{
+		{"set a 0 0 1\r\nx\r\nget a\r\nquit\r\n", "STORED\r\nVALUE a 0 1\r\nx\r\nEND\r\n"},
+	})
+}
+
+func TestTextLongLine(t *testing.T) {
+	s := newTestServer()
+
+	// A get of 200 keys is longer than the default buffer of a reader
+	var get strings.Builder
+	get.WriteString("get")
+	for i := range 200 {
+		fmt.Fprintf(&get, " key-of-a-long-multi-get-%03d", i)
+	}
+
+	converse(t, s.HandleTextConn, [][2]string{
+		{"set key-of-a-long-multi-get-199 0 0 1\r\nx\r\n", "STORED\r\n"},
+		{get.String() + "\r\n", "VALUE key-of-a-long-multi-get-199 0 1\r\nx\r\nEND\r\n"},
+		// A line over the limit is refused without closing the connection
+		{"get " + strings.Repeat("k", constants.MaxLineLength) + "\r\nversion\r\n", TextLineTooLong + "VERSION " + constants.Version + "\r\n"},
+	})
+}
+
+func TestTextTooLarge(t *testing.T) {
+	s := newTestServer()
+
+	// The data block is thrown away without allocating it, the next command is read after it
+	large := strings.Repeat("v", s.Manager.MaxPayload()+1)
+	converse(t, s.HandleTextConn, [][2]string{
+		{fmt.Sprintf("set k 0 0 %d noreply\r\n%s\r\n", len(large), large), "SERVER_ERROR object too large for cache\r\n"},
+		{fmt.Sprintf("ms k %d\r\n%s\r\n", len(large), large), "SERVER_ERROR object too large for cache\r\n"},
+		{"set k 0 0 1\r\nv\r\nget k\r\n", "STORED\r\nVALUE k 0 1\r\nv\r\nEND\r\n"},
+	})
+}
+
+func TestMetaProtocol(t *testing.T) {
+	s := newTestServer()
+
+	converse(t, s.HandleTextConn, [][2]string{
+		{"ms foo 3 F7 T100\r\nbar\r\n", "HD\r\n"},
+		{"mg foo v f t s h O1\r\n", "VA 3 f7 t100 s3 h0 O1\r\nbar\r\n"},
+		{"mg foo h\r\n", "HD h1\r\n"},
+		{"mg foo T300 t\r\n", "HD t300\r\n"},
+		{"mg Zm9v b k\r\n", "HD b kZm9v\r\n"},
+		{"mg missing v\r\n", "EN\r\n"},
+		{"mg missing v q\r\nmn\r\n", "MN\r\n"},
+		{"mg fresh s N30\r\n", "HD s0 W\r\n"},
+		{"ms foo 1 ME\r\nx\r\n", "NS\r\n"},
+		{"ms absent 1 MR\r\nx\r\n", "NS\r\n"},
+		{"ms foo 1 MR\r\ny\r\n", "HD\r\n"},
+		{"ms foo 1 MA\r\nz\r\nmg foo v\r\n", "HD\r\nVA 2\r\nyz\r\n"},
+		{"md foo q\r\nmd foo\r\n", "NF\r\n"},
+		{"ma counter N0 J10 v\r\n", "VA 2\r\n10\r\n"},
+		{"ma counter D5 v\r\n", "VA 2\r\n15\r\n"},
+		{"ma counter MD D6 v\r\n", "VA 1\r\n9\r\n"},
+		{"ma counter M- D20 v\r\n", "VA 1\r\n0\r\n"},
+		{"mg foo x\r\n", "CLIENT_ERROR invalid flag\r\n"},
+	})
+}
+
+func TestCompareAndSet(t *testing.T) {
+	s := newTestServer()
+
+	converse(t, s.HandleTextConn, [][2]string{
+		{"set foo 0 0 1\r\na\r\n", "STORED\r\n"},
+		{"gets foo\r\n", "VALUE foo 0 1 1\r\na\r\nEND\r\n"},
+		{"cas foo 3 0 1 1\r\nb\r\n", "STORED\r\n"},
+		{"cas foo 0 0 1 1\r\nc\r\n", "EXISTS\r\n"},
+		{"gets foo\r\n", "VALUE foo 3 1 2\r\nb\r\nEND\r\n"},
+		{"cas missing 0 0 1 1\r\nx\r\n", "NOT_FOUND\r\n"},
+		{"ms foo 1 C2 c\r\nd\r\n", "HD c3\r\n"},
+		{"ms foo 1 C2\r\ne\r\n", "EX\r\n"},
+		{"cas foo 0 -1 1 3\r\nf\r\n", "STORED\r\n"},
+		{"get foo\r\n", "END\r\n"},
+	})
+}
+
+func TestTextSlabStats(t *testing.T) {
+	s := newTestServer()
+
+	converse(t, s.HandleTextConn, [][2]string{
+		{"stats items\r\n", "END\r\n"},
+		{"set k 0 0 1\r\nv\r\n", "STORED\r\n"},
+		{"stats slabs\r\n", "STAT 1:chunk_size 128\r\nSTAT 1:chunks_per_page 8192\r\nSTAT 1:total_pages 1\r\n" +
+			"STAT 1:max_pages 0\r\nSTAT 1:used_chunks 1\r\nSTAT 1:free_chunks 8191\r\nSTAT 1:evicted 0\r\nSTAT 1:outofmemory 0\r\n" +
+			"STAT active_slabs 1\r\nSTAT total_malloced 1048576\r\nEND\r\n"},
+		{"stats sizes\r\n", "ERROR\r\n"},
+	})
+}
+
+func TestTextCacheMemLimit(t *testing.T) {
+	s := newTestServer()
+
+	converse(t, s.HandleTextConn, [][2]string{
+		{"set k 0 0 1\r\nv\r\n", "STORED\r\n"},
+		{"cache_memlimit 32\r\n", "OK\r\n"},
+		{"cache_memlimit\r\n", "CLIENT_ERROR bad command line format\r\n"},
+		{"cache_memlimit -1\r\n", "CLIENT_ERROR bad command line format\r\n"},
+		{"cache_memlimit 4294967295\r\n", "CLIENT_ERROR memory limit over the configured maximum\r\n"},
+		{"get k\r\n", "VALUE k 0 1\r\nv\r\nEND\r\n"},
+	})
+
+	if limit := s.Manager.LimitMaxBytes(); limit != 32*constants.MiB {
+		t.Errorf("limit_maxbytes: expected %d | get %d", 32*constants.MiB, limit)
+	}
+}
@@ -0,0 +1,89 @@
+package sketch
+
+import (
+	"hash/maphash"
+	"math/bits"
+)
+
+const (
+	depth      = 4  // Number of rows, every key has a counter in each
+	maxCount   = 15 // Counters stop growing at it
+	resetRatio = 10 // Counters are halved after resetRatio times the width increments
+)
+
+// Sketch is a count-min sketch estimating how often a key was seen, with periodic aging.
+// After every reset the counters are halved, so old popularity fades away.
+// It isn't safe for concurrent use.
+type Sketch struct {
+	rows  [depth][]uint8 // Counters of every row
+	mask  uint64         // Width of a row minus one, the width is a power of two
+	seed  maphash.Seed   // Seed of the key hash
+	added int            // Number of increments since the last reset
+	limit int            // Number of increments that triggers a reset
+}
+
+// New creates a sketch with rows of at least width counters.
+func New(width int) *Sketch {
+	width = 1 << bits.Len(uint(max(width, 2)-1)) // Round up to a power of two
+
+	s := &Sketch{
+		mask:  uint64(width - 1),
+		seed:  maphash.MakeSeed(),
+		limit: resetRatio * width,
+	}
+
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+
+	return s
+}
+
+// index returns the position of the key in the row, every row uses a different hash of the key.
+func (s *Sketch) index(hash uint64, row int) uint64 {
+	return (hash + uint64(row)*(bits.RotateLeft64(hash, 32)|1)) & s.mask
+}
+
+// Add counts one more occurrence of the key.
+func (s *Sketch) Add(key string) {
+	s.AddHash(maphash.String(s.seed, key))
+}
+
+// AddHash counts one more occurrence of the key with the given hash, for callers that hashed the key already.
+func (s *Sketch) AddHash(hash uint64) {
+	for row := range s.rows {
+		if counter := &s.rows[row][s.index(hash, row)]; *counter < maxCount {
+			*counter++
+		}
+	}
+
+	if s.added++; s.added >= s.limit {
+		s.reset()
+	}
+}
+
+// Estimate returns how often the key was seen, it never underestimates between resets.
+func (s *Sketch) Estimate(key string) uint8 {
+	return s.EstimateHash(maphash.String(s.seed, key))
+}
+
+// EstimateHash returns how often the key with the given hash was seen.
+func (s *Sketch) EstimateHash(hash uint64) uint8 {
+	estimate := uint8(maxCount)
+	for row := range s.rows {
+		estimate = min(estimate, s.rows[row][s.index(hash, row)])
+	}
+
+	return estimate
+}
+
+// reset halves every counter.
+func (s *Sketch) reset() {
+	for row := range s.rows {
+		for i := range s.rows[row] {
+			s.rows[row][i] >>= 1
+		}
+	}
+
+	s.added /= 2
+}
@@ -0,0 +1,49 @@
+package sketch
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestEstimate(t *testing.T) {
+	s := New(1000)
+
+	for range 5 {
+		s.Add("popular")
+	}
+	s.Add("rare")
+
+	if estimate := s.Estimate("popular"); estimate < 5 {
+		t.Errorf("popular: expected at least 5 | get %d", estimate)
+	}
+
+	if estimate := s.Estimate("rare"); estimate < 1 || estimate >= s.Estimate("popular") {
+		t.Errorf("rare: expected at least 1 and less than popular | get %d", estimate)
+	}
+
+	// counters stop growing at 15
+	for range 100 {
+		s.Add("popular")
+	}
+
+	if estimate := s.Estimate("popular"); estimate != maxCount {
+		t.Errorf("saturated: expected %d | get %d", maxCount, estimate)
+	}
+}
+
+func TestReset(t *testing.T) {
+	s := New(16)
+
+	for range 8 {
+		s.Add("key")
+	}
+
+	// other keys fill the sketch until the counters are halved
+	for i := range s.limit - 8 {
+		s.Add(fmt.Sprint(i))
+	}
+
+	if estimate := s.Estimate("key"); estimate < 4 || estimate > 7 {
+		t.Errorf("aged: expected about half of 8 | get %d", estimate)
+	}
+}
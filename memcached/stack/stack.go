@@ -48,6 +48,36 @@ func (s *Stack[T]) IsEmpty() bool {
 	return len(s.store) == 0 // Return true if the stack is empty, false otherwise.
 }
 
+// Len returns the number of elements in the stack.
+func (s *Stack[T]) Len() int {
+	return len(s.store)
+}
+
+// Count returns the number of elements that match.
+func (s *Stack[T]) Count(match func(T) bool) int {
+	count := 0
+	for _, value := range s.store {
+		if match(value) {
+			count++
+		}
+	}
+
+	return count
+}
+
+// Filter removes every element that doesn't keep, preserving the order of the rest.
+func (s *Stack[T]) Filter(keep func(T) bool) {
+	kept := s.store[:0]
+	for _, value := range s.store {
+		if keep(value) {
+			kept = append(kept, value)
+		}
+	}
+
+	clear(s.store[len(kept):]) // Drop references to the removed elements
+	s.store = kept
+}
+
 // Peek returns the top element of the stack without removing it.
 // If the stack is empty, it returns an error.
 func (s *Stack[T]) Peek() (T, error) {